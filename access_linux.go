@@ -0,0 +1,185 @@
+//go:build linux
+// +build linux
+
+package gomagtek
+
+import (
+	"path/filepath"
+	"io/ioutil"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+	"fmt"
+	"os"
+)
+
+const accessReadWrite uint32 = 0x06 // R_OK | W_OK
+
+// DiagnoseAccess finds the Magtek readers known to sysfs and checks whether
+// the current user can open the USB device node of each for reading and
+// writing. For each node that cannot be opened it explains whether the mode,
+// the ownership, or a missing group membership is to blame. The sysfs and
+// dev arguments are where sysfs and device nodes are found, normally "/sys"
+// and "/dev".
+func DiagnoseAccess(sysfs, dev string) (ads []*AccessDiagnosis, err error) {
+
+	dirs, err := filepath.Glob(filepath.Join(sysfs, "bus", "usb", "devices", "*"))
+
+	if err != nil {
+		return ads, fmt.Errorf("%s: %v", getFunctionInfo(), err)
+	}
+
+	for _, dir := range dirs {
+
+		if vid, _ := readSysfs(dir, "idVendor"); vid != fmt.Sprintf("%04x", MagtekVendorID) {
+			continue
+		}
+
+		ad := new(AccessDiagnosis)
+		ad.ProductID, _ = readSysfs(dir, "idProduct")
+
+		bus, e1 := readSysfs(dir, "busnum")
+		addr, e2 := readSysfs(dir, "devnum")
+
+		if e1 != nil || e2 != nil {
+			continue
+		}
+
+		ad.BusNumber, _ = strconv.Atoi(bus)
+		ad.BusAddress, _ = strconv.Atoi(addr)
+		ad.Path = filepath.Join(dev, "bus", "usb",
+			fmt.Sprintf("%03d", ad.BusNumber), fmt.Sprintf("%03d", ad.BusAddress))
+
+		ad.diagnose()
+		ads = append(ads, ad)
+	}
+
+	return ads, nil
+}
+
+// diagnose checks the device node against the identity of the current user.
+func (ad *AccessDiagnosis) diagnose() {
+
+	fi, err := os.Stat(ad.Path)
+
+	if err != nil {
+		ad.Problems = append(ad.Problems, fmt.Sprintf("cannot stat device node: %v", err))
+		return
+	}
+
+	st, ok := fi.Sys().(*syscall.Stat_t)
+
+	if !ok {
+		ad.Problems = append(ad.Problems, "cannot determine device node ownership")
+		return
+	}
+
+	ad.Mode = fi.Mode().Perm()
+	ad.Owner = userName(st.Uid)
+	ad.Group = groupName(st.Gid)
+	ad.CanOpen = syscall.Access(ad.Path, accessReadWrite) == nil
+
+	if ad.CanOpen {
+		return
+	}
+
+	uid := os.Getuid()
+
+	switch {
+
+	case uint32(uid) == st.Uid:
+		ad.Problems = append(ad.Problems, fmt.Sprintf(
+			"node is owned by you but mode %v lacks owner read/write", ad.Mode))
+
+	case inGroup(st.Gid, os.Getgroups):
+		ad.Problems = append(ad.Problems, fmt.Sprintf(
+			"you are in group %s but mode %v lacks group read/write", ad.Group, ad.Mode))
+
+	case ad.Mode & 0060 == 0060 && inGroup(st.Gid, memberGroups):
+		ad.Problems = append(ad.Problems, fmt.Sprintf(
+			"you were added to group %s, but not in this session; log out and back in", ad.Group))
+
+	case ad.Mode & 0060 == 0060:
+		ad.Problems = append(ad.Problems, fmt.Sprintf(
+			"you are not a member of group %s, which has read/write access", ad.Group))
+
+	default:
+		ad.Problems = append(ad.Problems, fmt.Sprintf(
+			"node is owned by %s:%s with mode %v, which gives you no read/write access",
+			ad.Owner, ad.Group, ad.Mode))
+	}
+
+	ad.Problems = append(ad.Problems,
+		"install the rules from UdevRules, or run 'util doctor -udev', to fix access")
+}
+
+// readSysfs reads a sysfs attribute with surrounding whitespace removed.
+func readSysfs(dir, attr string) (string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, attr))
+	return strings.TrimSpace(string(b)), err
+}
+
+// inGroup reports whether gid is among the group IDs returned by groups.
+func inGroup(gid uint32, groups func() ([]int, error)) bool {
+
+	gids, err := groups()
+
+	if err != nil {
+		return false
+	}
+
+	for _, g := range gids {
+		if uint32(g) == gid {
+			return true
+		}
+	}
+
+	return false
+}
+
+// memberGroups returns the group IDs the current user is a member of
+// according to the group database, which may include groups that the
+// current session has not picked up yet.
+func memberGroups() (gids []int, err error) {
+
+	u, err := user.Current()
+
+	if err != nil {
+		return gids, err
+	}
+
+	ids, err := u.GroupIds()
+
+	for _, id := range ids {
+		if g, e := strconv.Atoi(id); e == nil {
+			gids = append(gids, g)
+		}
+	}
+
+	return gids, err
+}
+
+// userName returns the name of the user, or the numeric ID if unknown.
+func userName(uid uint32) string {
+
+	id := strconv.FormatUint(uint64(uid), 10)
+
+	if u, err := user.LookupId(id); err == nil {
+		return u.Username
+	}
+
+	return id
+}
+
+// groupName returns the name of the group, or the numeric ID if unknown.
+func groupName(gid uint32) string {
+
+	id := strconv.FormatUint(uint64(gid), 10)
+
+	if g, err := user.LookupGroupId(id); err == nil {
+		return g.Name
+	}
+
+	return id
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package gomagtek
+
+import "fmt"
+
+// DiagnoseAccess is only supported on Linux.
+func DiagnoseAccess(sysfs, dev string) (ads []*AccessDiagnosis, err error) {
+	return ads, fmt.Errorf("%s: not supported on this platform", getFunctionInfo())
+}
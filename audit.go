@@ -0,0 +1,159 @@
+package gomagtek
+
+import (
+	"encoding/json"
+	"encoding/hex"
+	"context"
+	"os/user"
+	"sync"
+	"time"
+	"fmt"
+	"os"
+)
+
+const (
+	AuditSuccess string = "success"
+	AuditFailure string = "failure"
+)
+
+// AuditRecord documents one NVRAM write or reset performed on a device:
+// when and where it happened, who ran it, which device it was, what changed,
+// and whether it succeeded.
+type AuditRecord struct {
+	Time time.Time
+	HostName string
+	UserName string
+	Operation string
+	VendorID string
+	ProductID string
+	BusNumber string
+	BusAddress string
+	FactorySN string
+	DeviceSN string
+	PropertyID string	`json:",omitempty"`
+	OldValue string	`json:",omitempty"`
+	NewValue string	`json:",omitempty"`
+	Result string
+	Error string	`json:",omitempty"`
+}
+
+// AuditSink receives audit records. Implementations must be safe for
+// concurrent use.
+type AuditSink interface {
+	Write(r *AuditRecord) error
+}
+
+// AuditFile is an AuditSink that appends each record to a file as a single
+// line of JSON. The file is never truncated or rewritten.
+type AuditFile struct {
+	mutex sync.Mutex
+	file *os.File
+}
+
+// NewAuditFile opens the audit file for appending, creating it if necessary.
+func NewAuditFile(fn string) (af *AuditFile, err error) {
+
+	f, err := os.OpenFile(fn, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", getFunctionInfo(), err)
+	}
+
+	return &AuditFile{file: f}, err
+}
+
+// Write appends the record to the audit file and syncs it to disk.
+func (af *AuditFile) Write(r *AuditRecord) (err error) {
+
+	b, err := json.Marshal(r)
+
+	if err != nil {
+		return fmt.Errorf("%s: %v", getFunctionInfo(), err)
+	}
+
+	af.mutex.Lock()
+	defer af.mutex.Unlock()
+
+	if _, err = af.file.Write(append(b, '\n')); err == nil {
+		err = af.file.Sync()
+	}
+
+	if err != nil {
+		err = fmt.Errorf("%s: %v", getFunctionInfo(), err)
+	}
+
+	return err
+}
+
+// Close closes the audit file.
+func (af *AuditFile) Close() error {
+	return af.file.Close()
+}
+
+// newAuditRecord starts an audit record for an operation on the device,
+// capturing the identity of the device and the user before the operation
+// alters or resets it. It returns nil if auditing is not enabled.
+func (d *Device) newAuditRecord(ctx context.Context, op string) (r *AuditRecord) {
+
+	if d.Audit == nil {
+		return nil
+	}
+
+	r = &AuditRecord {
+		Time: time.Now(),
+		Operation: op,
+		VendorID: d.GetVendorID(),
+		ProductID: d.GetProductID(),
+		BusNumber: d.GetBusNumber(),
+		BusAddress: d.GetBusAddress()}
+
+	r.HostName, _ = os.Hostname()
+
+	if u, err := user.Current(); err == nil {
+		r.UserName = u.Username
+	}
+
+	r.FactorySN, _ = d.GetFactorySNContext(ctx)
+	r.DeviceSN, _ = d.GetDeviceSNContext(ctx)
+
+	return r
+}
+
+// writeAudit completes the audit record with the outcome of the operation and
+// hands it to the audit sink. The operation error is returned unchanged if it
+// is set; otherwise any error from the sink is returned so that a change is
+// never silently left unrecorded.
+func (d *Device) writeAudit(r *AuditRecord, err error) (error) {
+
+	if r == nil {
+		return err
+	}
+
+	if r.Result = AuditSuccess; err != nil {
+		r.Result, r.Error = AuditFailure, err.Error()
+	}
+
+	if aerr := d.Audit.Write(r); err == nil {
+		err = aerr
+	}
+
+	return err
+}
+
+// withProperty records the property, old value, and new value of a write.
+// Values of properties that are not strings are recorded in hex.
+func (r *AuditRecord) withProperty(id uint8, old, new string) (*AuditRecord) {
+
+	if r == nil {
+		return r
+	}
+
+	r.PropertyID = fmt.Sprintf("0x%02X", id)
+	r.OldValue, r.NewValue = old, new
+
+	if p, ok := LookupProperty(r.PropertyID); ok && p.Type != "string" {
+		r.OldValue, r.NewValue = hex.EncodeToString([]byte(old)), hex.EncodeToString([]byte(new))
+	}
+
+	return r
+}
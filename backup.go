@@ -0,0 +1,190 @@
+package gomagtek
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"context"
+	"time"
+	"fmt"
+	"os"
+)
+
+const BackupVersion int = 1
+
+// Backup holds the identity and NVRAM property values of a device so that
+// its configuration can be restored to it or to a replacement device. Raw
+// property values are hex-encoded.
+type Backup struct {
+	Version int
+	Created time.Time
+	HostName string
+	VendorID string
+	ProductID string
+	SoftwareID string
+	ProductVer string
+	DeviceSN string
+	FactorySN string
+	Properties []BackupProperty
+}
+
+// BackupProperty holds the value of one property, or the error returned when
+// the device was asked for it.
+type BackupProperty struct {
+	ID uint8
+	Name string
+	Writable bool
+	Value string	`json:",omitempty"`
+	Error string	`json:",omitempty"`
+}
+
+// PropertyChange describes the difference between the current and desired
+// value of a device property.
+type PropertyChange struct {
+	Property
+	Old string
+	New string
+}
+
+// String renders the change in human-readable form.
+func (pc PropertyChange) String() string {
+	return fmt.Sprintf("%s (0x%02X): %s -> %s",
+		pc.Name, pc.ID, pc.Format(pc.Old), pc.Format(pc.New))
+}
+
+// Backup reads the identity and every documented property of the device.
+// Properties the device does not support are recorded with their error.
+func (d *Device) Backup() (*Backup, error) {
+	return d.BackupContext(context.Background())
+}
+
+// BackupContext is Backup with a context. Reading stops when the context is
+// done.
+func (d *Device) BackupContext(ctx context.Context) (b *Backup, err error) {
+
+	b = &Backup {
+		Version: BackupVersion,
+		Created: time.Now(),
+		VendorID: d.GetVendorID(),
+		ProductID: d.GetProductID()}
+
+	if b.HostName, err = os.Hostname(); err != nil {
+		return b, fmt.Errorf("%s: %w", getFunctionInfo(), err)
+	}
+
+	if b.SoftwareID, err = d.GetSoftwareIDContext(ctx); err != nil {
+		return b, fmt.Errorf("%s: %w", getFunctionInfo(), err)
+	}
+
+	b.DeviceSN, _ = d.GetDeviceSNContext(ctx)
+	b.FactorySN, _ = d.GetFactorySNContext(ctx)
+	b.ProductVer, _ = d.GetProductVerContext(ctx)
+
+	for _, p := range Properties {
+
+		if err = ctx.Err(); err != nil {
+			return b, fmt.Errorf("%s: %w", getFunctionInfo(), err)
+		}
+
+		bp := BackupProperty{ID: p.ID, Name: p.Name, Writable: p.Writable}
+
+		if v, err := d.getProperty(ctx, p.ID); err != nil {
+			bp.Error = err.Error()
+		} else {
+			bp.Value = hex.EncodeToString([]byte(v))
+		}
+
+		b.Properties = append(b.Properties, bp)
+	}
+
+	return b, nil
+}
+
+// NewBackupFromJSON decodes a backup, rejecting versions it does not know.
+func NewBackupFromJSON(j []byte) (b *Backup, err error) {
+
+	b = new(Backup)
+
+	if err = json.Unmarshal(j, b); err != nil {
+		return b, fmt.Errorf("%s: %w", getFunctionInfo(), err)
+	}
+
+	if b.Version < 1 || b.Version > BackupVersion {
+		err = fmt.Errorf("%s: unsupported backup version %d", getFunctionInfo(), b.Version)
+	}
+
+	return b, err
+}
+
+// JSON encodes the backup in indented JSON format.
+func (b *Backup) JSON() ([]byte, error) {
+	return json.MarshalIndent(b, "", "\t")
+}
+
+// PlanRestore compares the writable properties in the backup with the
+// current values on the device and returns the changes a restore would make.
+// Properties that could not be read when the backup was taken, or that the
+// device does not support, are left out.
+func (d *Device) PlanRestore(b *Backup) ([]PropertyChange, error) {
+	return d.PlanRestoreContext(context.Background(), b)
+}
+
+// PlanRestoreContext is PlanRestore with a context.
+func (d *Device) PlanRestoreContext(ctx context.Context, b *Backup) (pcs []PropertyChange, err error) {
+
+	if b.ProductID != d.GetProductID() {
+		return pcs, fmt.Errorf("%s: backup product ID %s does not match device product ID %s",
+			getFunctionInfo(), b.ProductID, d.GetProductID())
+	}
+
+	for _, bp := range b.Properties {
+
+		p, ok := LookupProperty(bp.Name)
+
+		if !ok || p.ID != bp.ID || !p.Writable || len(bp.Error) > 0 {
+			continue
+		}
+
+		nb, err := hex.DecodeString(bp.Value)
+
+		if err != nil {
+			return pcs, fmt.Errorf("%s: property %s: %w", getFunctionInfo(), bp.Name, err)
+		}
+
+		if err = ctx.Err(); err != nil {
+			return pcs, fmt.Errorf("%s: %w", getFunctionInfo(), err)
+		}
+
+		old, err := d.getProperty(ctx, p.ID)
+
+		if err != nil {
+			continue
+		}
+
+		if old != string(nb) {
+			pcs = append(pcs, PropertyChange{p, old, string(nb)})
+		}
+	}
+
+	return pcs, nil
+}
+
+// Restore writes the writable properties in the backup to the device and
+// returns the changes that were applied. Each write is verified, and the
+// restore stops at the first failure. A reset or power cycle is needed before
+// most changes take effect.
+func (d *Device) Restore(b *Backup) ([]PropertyChange, error) {
+	return d.RestoreContext(context.Background(), b)
+}
+
+// RestoreContext is Restore with a context. No further writes are started
+// once the context is done.
+func (d *Device) RestoreContext(ctx context.Context, b *Backup) (applied []PropertyChange, err error) {
+
+	pcs, err := d.PlanRestoreContext(ctx, b)
+
+	if err != nil {
+		return applied, err
+	}
+
+	return d.applyChanges(ctx, "Restore", pcs)
+}
@@ -0,0 +1,203 @@
+package gomagtek
+
+import (
+	"encoding/csv"
+	"context"
+	"strings"
+	"sort"
+	"fmt"
+	"io"
+)
+
+const (
+	BatchApplied string = "applied"
+	BatchSkipped string = "skipped"
+	BatchMissing string = "missing"
+	BatchFailed string = "failed"
+)
+
+// SerialMapping maps the factory serial number of a reader to the device
+// serial number, and optionally the asset tag, it should be given.
+type SerialMapping struct {
+	FactorySN string
+	DeviceSN string
+	AssetTag string
+}
+
+// BatchResult records what was done with one reader or mapping entry during
+// a bulk provisioning run.
+type BatchResult struct {
+	SerialMapping
+	Status string
+	Detail string
+}
+
+// serialMapHeader holds the column names of a mapping file header row.
+var serialMapHeader = []string {"factory_sn", "device_sn", "asset_tag"}
+
+// ReadSerialMap reads a CSV mapping file with columns factory SN, device SN,
+// and an optional asset tag. The first row is skipped as a header if its
+// fields are the column names factory_sn, device_sn, and optionally asset_tag,
+// in any case; any other first row is read as a mapping. Blank lines are
+// ignored, and rows without a factory serial number are rejected. Entries are
+// keyed by factory serial number.
+func ReadSerialMap(r io.Reader) (m map[string]SerialMapping, err error) {
+
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	records, err := cr.ReadAll()
+
+	if err != nil {
+		return m, fmt.Errorf("%s: %v", getFunctionInfo(), err)
+	}
+
+	m = make(map[string]SerialMapping)
+
+	for n, rec := range records {
+
+		if n == 0 && isSerialMapHeader(rec) {
+			continue
+		}
+
+		if len(rec) < 2 {
+			return m, fmt.Errorf("%s: line %d: expected at least 2 fields",
+				getFunctionInfo(), n + 1)
+		}
+
+		sm := SerialMapping{FactorySN: strings.TrimSpace(rec[0]), DeviceSN: strings.TrimSpace(rec[1])}
+
+		if len(rec) > 2 {
+			sm.AssetTag = strings.TrimSpace(rec[2])
+		}
+
+		if len(sm.FactorySN) == 0 {
+			return m, fmt.Errorf("%s: line %d: factory serial number missing",
+				getFunctionInfo(), n + 1)
+		}
+
+		if _, ok := m[sm.FactorySN]; ok {
+			return m, fmt.Errorf("%s: line %d: duplicate factory serial number %s",
+				getFunctionInfo(), n + 1, sm.FactorySN)
+		}
+
+		m[sm.FactorySN] = sm
+	}
+
+	return m, err
+}
+
+// isSerialMapHeader reports whether the row is a mapping file header row.
+func isSerialMapHeader(rec []string) bool {
+
+	if len(rec) < 2 || len(rec) > len(serialMapHeader) {
+		return false
+	}
+
+	for i, f := range rec {
+		if !strings.EqualFold(strings.TrimSpace(f), serialMapHeader[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ApplySerialMap looks up the factory serial number of each device and sets
+// its device serial number from the mapping. Devices that already have the
+// mapped serial number, or any serial number unless overwrite is true, are
+// skipped. Mapping entries with no matching device are reported as missing.
+func ApplySerialMap(devices []*Device, m map[string]SerialMapping, overwrite bool) ([]BatchResult) {
+	return ApplySerialMapContext(context.Background(), devices, m, overwrite)
+}
+
+// ApplySerialMapContext is ApplySerialMap with a context. Devices not yet
+// handled when the context is done are reported as failed.
+func ApplySerialMapContext(ctx context.Context, devices []*Device, m map[string]SerialMapping, overwrite bool) (rs []BatchResult) {
+
+	found := make(map[string]bool)
+
+	for _, d := range devices {
+
+		var r BatchResult
+
+		fsn, err := d.GetFactorySNContext(ctx)
+
+		if err != nil {
+			r.Status, r.Detail = BatchFailed, err.Error()
+			rs = append(rs, r)
+			continue
+		}
+
+		sm, ok := m[fsn]
+		r.SerialMapping = sm
+		r.FactorySN = fsn
+
+		if !ok {
+			r.Status, r.Detail = BatchSkipped, "factory serial number not in mapping"
+			rs = append(rs, r)
+			continue
+		}
+
+		found[fsn] = true
+		sn, err := d.GetDeviceSNContext(ctx)
+
+		switch {
+
+		case err != nil:
+			r.Status, r.Detail = BatchFailed, err.Error()
+
+		case sn == sm.DeviceSN:
+			r.Status, r.Detail = BatchSkipped, "device serial number already set"
+
+		case len(sn) > 0 && !overwrite:
+			r.Status, r.Detail = BatchSkipped, fmt.Sprintf("device serial number already set to %s", sn)
+
+		default:
+			if err = d.SetDeviceSNContext(ctx, sm.DeviceSN); err != nil {
+				r.Status, r.Detail = BatchFailed, err.Error()
+			} else {
+				r.Status = BatchApplied
+			}
+		}
+
+		rs = append(rs, r)
+	}
+
+	var missing []string
+
+	for fsn := range m {
+		if !found[fsn] {
+			missing = append(missing, fsn)
+		}
+	}
+
+	sort.Strings(missing)
+
+	for _, fsn := range missing {
+		rs = append(rs, BatchResult{SerialMapping: m[fsn], Status: BatchMissing,
+			Detail: "no attached reader with factory serial number"})
+	}
+
+	return rs
+}
+
+// WriteBatchResults writes bulk provisioning results in CSV format.
+func WriteBatchResults(w io.Writer, rs []BatchResult) (err error) {
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"factory_sn", "device_sn", "asset_tag", "status", "detail"})
+
+	for _, r := range rs {
+		cw.Write([]string{r.FactorySN, r.DeviceSN, r.AssetTag, r.Status, r.Detail})
+	}
+
+	cw.Flush()
+
+	if err = cw.Error(); err != nil {
+		err = fmt.Errorf("%s: %v", getFunctionInfo(), err)
+	}
+
+	return err
+}
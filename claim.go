@@ -0,0 +1,91 @@
+package gomagtek
+
+import "fmt"
+
+// Claim claims the HID interface of the device, which reading input reports
+// and some vendor commands require. On Linux the interface is normally bound
+// to the usbhid kernel driver; if AutoDetach is set, the driver is detached
+// while the interface is claimed and reattached when it is released, so that
+// keyboard-mode readers keep typing afterwards. The interface is released by
+// Release, or by Close even if an operation in between failed. Claiming an
+// interface that is already claimed does nothing.
+func (d *Device) Claim() (err error) {
+
+	d.cmdMutex.Lock()
+	defer d.cmdMutex.Unlock()
+
+	if !d.usb {
+		return fmt.Errorf("%s: claiming interfaces not supported by transport", getFunctionInfo())
+	}
+
+	if d.intf != nil {
+		return err
+	}
+
+	if err = d.SetAutoDetach(d.AutoDetach); err != nil {
+		return fmt.Errorf("%s: %w", getFunctionInfo(), classify(err))
+	}
+
+	num, err := d.ActiveConfigNum()
+
+	if err != nil {
+		return fmt.Errorf("%s: %w", getFunctionInfo(), classify(err))
+	}
+
+	cfg, err := d.Config(num)
+
+	if err != nil {
+		return fmt.Errorf("%s: %w", getFunctionInfo(), classify(err))
+	}
+
+	intf, err := cfg.Interface(int(InterfaceNumber), 0)
+
+	if err != nil {
+		cfg.Close()
+		return fmt.Errorf("%s: %w", getFunctionInfo(), classify(err))
+	}
+
+	d.config, d.intf = cfg, intf
+
+	return err
+}
+
+// Release releases the HID interface claimed by Claim, reattaching the kernel
+// driver if it was detached. Releasing an interface that is not claimed does
+// nothing.
+func (d *Device) Release() (err error) {
+
+	d.cmdMutex.Lock()
+	defer d.cmdMutex.Unlock()
+
+	return d.release()
+}
+
+// Claimed reports whether the HID interface is claimed.
+func (d *Device) Claimed() bool {
+
+	d.cmdMutex.Lock()
+	defer d.cmdMutex.Unlock()
+
+	return d.intf != nil
+}
+
+// release releases the interface and its configuration. The caller must hold
+// the command lock.
+func (d *Device) release() (err error) {
+
+	if d.intf != nil {
+		d.intf.Close()
+		d.intf = nil
+	}
+
+	if d.config != nil {
+		if err = d.config.Close(); err != nil {
+			err = fmt.Errorf("%s: %w", getFunctionInfo(), classify(err))
+		}
+		d.config = nil
+	}
+
+	return err
+}
+
@@ -2,6 +2,8 @@ package main
 
 import "github.com/jscherff/gomagtek"
 import "github.com/google/gousb"
+import "context"
+import "errors"
 import "log"
 import "fmt"
 import "os"
@@ -10,33 +12,81 @@ var printFormat string = "\tVendor ID:\t%s\n\tProduct ID:\t%s\n\t" +
 	"Software ID:\t%s\n\tSerial Num:\t%s\n\tHost Name:\t%s\n\n"
 
 func main() {
+	os.Exit(run())
+}
+
+// run configures every device and returns the exit code: 0 if every device
+// was configured, 1 otherwise, or 4 if devices were found but could not be
+// opened for lack of permission.
+func run() (code int) {
 
 	context := gousb.NewContext()
 	defer context.Close()
 
-	// Open devices that report a Magtek vendor ID, 0x0801.
-	// We omit error checking on OpenDevices() because this
-	// function terminates with 'libusb: not found [code -5]'
-	// on Windows systems.
+	// Open devices that report a Magtek vendor ID, 0x0801, reporting
+	// those that were found but could not be opened, so that a lack of
+	// permission is not mistaken for the absence of devices.
 
-	devices, _ := context.OpenDevices(func(desc *gousb.DeviceDesc) bool {
-		return uint16(desc.Vendor) == gomagtek.MagtekVendorID
-	})
+	devices, errs := gomagtek.OpenDevices(context)
 
-	if len(devices) == 0 {
-		log.Fatalf("No Magtek devices found")
+	for _, device := range devices {
+		defer device.Close()
 	}
 
-	for _, device := range devices {
+	denied := false
 
-		defer device.Close()
+	for _, err := range errs {
+		log.Printf("Error: %v", err)
+		denied = denied || errors.Is(err, gomagtek.ErrAccess)
+		code = 1
+	}
 
-		device, err := gomagtek.NewDevice(device)
+	switch {
 
-		if err != nil {
-			log.Fatalf("Error: %v", err); continue
+	case len(devices) == 0 && denied:
+		log.Printf("Magtek devices found but not accessible; run 'util doctor'")
+		return 4
+
+	case len(devices) == 0 && len(errs) > 0:
+		return 1
+
+	case len(devices) == 0:
+		log.Printf("No Magtek devices found")
+		return 1
+	}
+
+	hostName, err := os.Hostname()
+
+	if err != nil {
+		log.Printf("Error: %v", err)
+		return 1
+	}
+
+	// Configure the devices through a fleet so that a failure on one
+	// device is reported without stopping the others.
+
+	fleet := gomagtek.NewFleet(gomagtek.DefaultFleetConcurrency, 0)
+
+	for _, r := range fleet.Run(devices, configure(hostName)) {
+
+		if r.Error != nil {
+			log.Printf("Error: bus %s address %s: %v", r.BusNumber, r.BusAddress, r.Error)
+			code = 1
+			continue
 		}
 
+		fmt.Print(r.Output)
+	}
+
+	return code
+}
+
+// configure returns a FleetFunc that sets the serial number of a device if it
+// is empty and outputs the device information before and after.
+func configure(hostName string) (gomagtek.FleetFunc) {
+
+	return func(ctx context.Context, device *gomagtek.Device) (interface{}, error) {
+
 		vendorID := device.GetVendorID()
 		productID := device.GetProductID()
 
@@ -47,44 +97,37 @@ func main() {
 		// criptor; however, this value is not refreshed until
 		// the device is power-cycled.
 
-		hostName, err := os.Hostname()
+		softwareID, err := device.GetSoftwareIDContext(ctx)
 
 		if err != nil {
-			log.Fatalf("Error: %v", err); continue
+			return nil, err
 		}
 
-		softwareID, err := device.GetSoftwareID()
+		serialNum, err := device.GetDeviceSNContext(ctx)
 
 		if err != nil {
-			log.Fatalf("Error: %v", err); continue
+			return nil, err
 		}
 
-		serialNum, err := device.GetDeviceSN()
-
-		if err != nil {
-			log.Fatalf("Error: %v", err); continue
-		}
-
-		fmt.Printf("BEFORE\n" + printFormat, vendorID, productID,
+		out := fmt.Sprintf("BEFORE\n" + printFormat, vendorID, productID,
 			softwareID, serialNum, hostName)
 
 		if len(serialNum) == 0 {
 
 			serialNum = "24FA12C" //TODO: obtain from server
-			err = device.SetDeviceSN(serialNum)
 
-			if err != nil {
-				log.Fatalf("Error: %v", err); continue
+			if err = device.SetDeviceSNContext(ctx, serialNum); err != nil {
+				return out, err
 			}
 
-			serialNum, err = device.GetDeviceSN()
-
-			if err != nil {
-				log.Fatalf("Error: %v", err); continue
+			if serialNum, err = device.GetDeviceSNContext(ctx); err != nil {
+				return out, err
 			}
 		}
 
-		fmt.Printf("AFTER\n" + printFormat, vendorID, productID,
+		out += fmt.Sprintf("AFTER\n" + printFormat, vendorID, productID,
 			softwareID, serialNum, hostName)
+
+		return out, err
 	}
 }
@@ -48,8 +48,47 @@ const (
 
 	PropSoftwareID uint8 = 0x00
 	PropDeviceSN uint8 = 0x01
+	PropPollingInterval uint8 = 0x02
 	PropFactorySN uint8 = 0x03
 	PropProductVer uint8 = 0x04
+	PropTrackIDEnable uint8 = 0x05
+	PropISOTrackMask uint8 = 0x07
+	PropAAMVATrackMask uint8 = 0x08
+	PropMaxPacketSize uint8 = 0x0A
+	PropInterfaceType uint8 = 0x10
+	PropDataSendFlags uint8 = 0x14
+	PropMPFlags uint8 = 0x15
+	PropActiveKeymap uint8 = 0x16
+	PropKeypressConversion uint8 = 0x17
+	PropCRCFlag uint8 = 0x19
+	PropKbSureSwipeFlag uint8 = 0x1A
+	PropDecodeEnable uint8 = 0x1B
+	PropSSJISType2 uint8 = 0x1C
+	PropESJISType2 uint8 = 0x1D
+	PropPreCardString uint8 = 0x1E
+	PropPostCardString uint8 = 0x1F
+	PropPreTrackString uint8 = 0x20
+	PropPostTrackString uint8 = 0x21
+	PropTerminationString uint8 = 0x22
+	PropFS uint8 = 0x23
+	PropSSTrack1ISO uint8 = 0x24
+	PropSSTrack2ISO uint8 = 0x25
+	PropSSTrack3ISO uint8 = 0x26
+	PropSSTrack3AAMVA uint8 = 0x27
+	PropSSTrack2Bit7 uint8 = 0x28
+	PropSSTrack3Bit7 uint8 = 0x29
+	PropES uint8 = 0x2B
+	PropFormatCode uint8 = 0x2C
+	PropESTrack1 uint8 = 0x2D
+	PropESTrack2 uint8 = 0x2E
+	PropESTrack3 uint8 = 0x2F
+	PropSendEncCounter uint8 = 0x30
+	PropMaskOtherCards uint8 = 0x31
+	PropMSRDirection uint8 = 0x32
+	PropCardInserted uint8 = 0x33
+	PropSendClearAAMVA uint8 = 0x34
+	PropHidSureSwipeFlag uint8 = 0x38
+	PropHostPollTimeout uint8 = 0x52
 
 	DefaultSNLength int = 7
 )
@@ -7,6 +7,7 @@ package gomagtek
 import (
 	"encoding/json"
 	"encoding/xml"
+	"context"
 	"os"
 )
 
@@ -31,6 +32,7 @@ type DeviceInfo struct {
 	DeviceVer	string	`json:",omitempty" xml:",omitempty" csv:",omitempty"`
 	MaxPktSize	string	`json:",omitempty" xml:",omitempty" csv:",omitempty"`
 	BufferSize	string	`json:",omitempty" xml:",omitempty" csv:",omitempty"`
+	Observed	string	`json:",omitempty" xml:",omitempty" csv:",omitempty"`
 }
 
 type DeviceInfoMin struct {
@@ -54,6 +56,7 @@ type DeviceInfoMin struct {
 	DeviceVer	string	`json:"-" xml:"-" csv:"-"`
 	MaxPktSize	string	`json:"-" xml:"-" csv:"-"`
 	BufferSize	string	`json:"-" xml:"-" csv:"-"`
+	Observed	string	`json:"-" xml:"-" csv:"-"`
 }
 
 var ImportMap = map[string]string {
@@ -76,7 +79,8 @@ var ImportMap = map[string]string {
 	"device_speed":	"DeviceSpeed",
 	"device_ver":	"DeviceVer",
 	"max_pkt_size":	"MaxPktSize",
-	"buffer_size":	"BufferSize"}
+	"buffer_size":	"BufferSize",
+	"observed":	"Observed"}
 
 var ExportMap = map[string]string {
 	"HostName":	"host_name",
@@ -98,9 +102,15 @@ var ExportMap = map[string]string {
 	"DeviceSpeed":	"device_speed",
 	"DeviceVer":	"device_ver",
 	"MaxPktSize":	"max_pkt_size",
-	"BufferSize":	"buffer_size"}
+	"BufferSize":	"buffer_size",
+	"Observed":	"observed"}
 
-func NewDeviceInfo(d *Device) (i *DeviceInfo, errs []error) {
+func NewDeviceInfo(d *Device) (*DeviceInfo, []error) {
+	return NewDeviceInfoContext(context.Background(), d)
+}
+
+// NewDeviceInfoContext is NewDeviceInfo with a context.
+func NewDeviceInfoContext(ctx context.Context, d *Device) (i *DeviceInfo, errs []error) {
 
 	var e error
 
@@ -118,13 +128,13 @@ func NewDeviceInfo(d *Device) (i *DeviceInfo, errs []error) {
 		MaxPktSize:	d.GetMaxPktSize()}
 
 	if i.HostName, e = os.Hostname(); e != nil {errs = append(errs, e)}
-	if i.DeviceSN, e = d.GetDeviceSN(); e != nil {errs = append(errs, e)}
-	if i.SoftwareID, e = d.GetSoftwareID(); e != nil {errs = append(errs, e)}
-	if i.VendorName, e = d.GetVendorName(); e != nil {errs = append(errs, e)}
-	if i.ProductName, e = d.GetProductName(); e != nil {errs = append(errs, e)}
-	if i.ProductVer, e = d.GetProductVer(); e != nil {errs = append(errs, e)}
-	if i.FactorySN, e = d.GetFactorySN(); e != nil {errs = append(errs, e)}
-	if i.DescriptSN, e = d.GetDescriptSN(); e != nil {errs = append(errs, e)}
+	if i.DeviceSN, e = d.GetDeviceSNContext(ctx); e != nil {errs = append(errs, e)}
+	if i.SoftwareID, e = d.GetSoftwareIDContext(ctx); e != nil {errs = append(errs, e)}
+	if i.VendorName, e = d.GetVendorNameContext(ctx); e != nil {errs = append(errs, e)}
+	if i.ProductName, e = d.GetProductNameContext(ctx); e != nil {errs = append(errs, e)}
+	if i.ProductVer, e = d.GetProductVerContext(ctx); e != nil {errs = append(errs, e)}
+	if i.FactorySN, e = d.GetFactorySNContext(ctx); e != nil {errs = append(errs, e)}
+	if i.DescriptSN, e = d.GetDescriptSNContext(ctx); e != nil {errs = append(errs, e)}
 	if i.BufferSize, e = d.GetBufferSize(); e != nil {errs = append(errs, e)}
 
 	return i, errs
@@ -56,7 +56,7 @@ func NewDeviceDescriptor(d *gousb.Device) (ndd *DeviceDescriptor, err error) {
 			data[16],
 			data[17]}
 	} else {
-		err = fmt.Errorf("%s: %v", getFunctionInfo(), err)
+		err = fmt.Errorf("%s: %w", getFunctionInfo(), err)
 	}
 
 	return ndd, err
@@ -100,7 +100,7 @@ func NewConfigDescriptor(d *gousb.Device) (ncd *ConfigDescriptor, err error) {
 			data[7],
 			data[8]}
 	} else {
-		err = fmt.Errorf("%s: %v", getFunctionInfo(), err)
+		err = fmt.Errorf("%s: %w", getFunctionInfo(), err)
 	}
 
 	return ncd, err
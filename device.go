@@ -2,8 +2,11 @@ package gomagtek
 
 import (
 	"github.com/google/gousb"
+	"context"
 	"strconv"
+	"strings"
 	"math"
+	"sync"
 	"time"
 	"fmt"
 )
@@ -11,27 +14,69 @@ import (
 // Device represents a USB device. The Device struct Desc field contains all
 // information about the device. It includes the raw device descriptor, the
 // config descriptor of the active config, and the size of the data buffer
-// required by the device for vendor commands sent via control transfer.
+// required by the device for vendor commands sent via control transfer. If
+// SerialPolicy is set, device serial numbers are validated against it before
+// they are written to device NVRAM; erasing the serial number is not subject
+// to it. OperationPolicy restricts which writes and resets are allowed at
+// all. If Audit is set, every NVRAM write and reset, including those blocked
+// by policy, is recorded to it.
+//
+// Timeout, if set, bounds each control transfer; otherwise the gousb default
+// applies. The ...Context variants of the methods also bound each transfer by
+// the context deadline and stop between transfers when the context is
+// canceled. ResetDelay is the time DeviceReset waits for the device to
+// reinitialize.
+//
+// A Device is safe for concurrent use. Each vendor command and its response
+// are exchanged under a per-device lock, so concurrent calls never receive
+// each other's responses, and verified writes do not interleave with one
+// another. Transport, if set, replaces the underlying gousb device for
+// control transfers. RetryPolicy governs the retrying of vendor commands that
+// fail with transient errors; if it is nil, commands are not retried. Stats
+// returns counts of the commands sent and the errors encountered. AutoDetach
+// lets Claim detach the kernel driver from the HID interface; see Claim. If
+// Logger is set, diagnostic messages are sent to it.
 type Device struct {
 	*gousb.Device
 	BufferSize int
 	DeviceDescriptor *DeviceDescriptor
 	ConfigDescriptor *ConfigDescriptor
-}
-
-// NewDevice constructs a new Device.
-func NewDevice(d *gousb.Device) (nd *Device, err error) {
-
-	nd = &Device{d, 0, new(DeviceDescriptor), new(ConfigDescriptor)}
-
-	err = nd.findBufferSize()
-
-	if err != nil {
-		return nd, err
-	}
-
-	_ = nd.getDeviceDescriptor()
-	_ = nd.getConfigDescriptor()
+	SerialPolicy SerialPolicy
+	OperationPolicy *OperationPolicy
+	Audit AuditSink
+	Timeout time.Duration
+	ResetDelay time.Duration
+	Transport Transport
+	RetryPolicy *RetryPolicy
+	AutoDetach bool
+	Logger Logger
+	stats commandStats
+	config *gousb.Config
+	intf *gousb.Interface
+	descLoaded bool
+	strict bool
+	usb bool
+	initMutex sync.Mutex
+	cmdMutex sync.Mutex
+	writeMutex sync.Mutex
+}
+
+const DefaultResetDelay time.Duration = 5 * time.Second
+
+// NewDevice constructs a new Device. By default the buffer size is found by
+// probing the device with vendor commands and the descriptors are read, with
+// descriptor errors ignored; options change this behavior.
+func NewDevice(d *gousb.Device, opts ...DeviceOption) (nd *Device, err error) {
+
+	nd = &Device {
+		Device: d,
+		DeviceDescriptor: new(DeviceDescriptor),
+		ConfigDescriptor: new(ConfigDescriptor),
+		ResetDelay: DefaultResetDelay,
+		RetryPolicy: NewRetryPolicy(),
+		usb: true}
+
+	err = nd.open(opts)
 
 	return nd, err
 }
@@ -46,6 +91,14 @@ func (d *Device) GetBusAddress() string {
 	return strconv.Itoa(d.Desc.Address)
 }
 
+// GetPortPath retrieves the physical location of the device as the bus number
+// followed by the chain of hub port numbers, e.g. "1-2.4". Unlike the bus
+// address, the port path stays the same when the device is reset or replugged
+// into the same port.
+func (d *Device) GetPortPath() string {
+	return portPath(d.Desc)
+}
+
 // GetDeviceSpeed retrieves the negotiated operating speed of the device.
 func (d *Device) GetDeviceSpeed() string {
 	return d.Desc.Speed.String()
@@ -93,57 +146,119 @@ func (d *Device) GetMaxPktSize() string {
 
 // GetBufferSize retrieves the size of the device data buffer.
 func (d *Device) GetBufferSize() (string, error) {
+
+	if err := d.ensureBufferSize(); err != nil {
+		return "", fmt.Errorf("%s: %w", getFunctionInfo(), err)
+	}
+
 	return strconv.Itoa(d.BufferSize), nil
 }
 
 // GetSoftwareID retrieves the software ID from the device NVRAM.
 func (d *Device) GetSoftwareID() (string, error) {
-	return d.getProperty(PropSoftwareID)
+	return d.GetSoftwareIDContext(context.Background())
+}
+
+// GetSoftwareIDContext is GetSoftwareID with a context.
+func (d *Device) GetSoftwareIDContext(ctx context.Context) (string, error) {
+	return d.getProperty(ctx, PropSoftwareID)
 }
 
 // GetProductVer retrieves the MagneSafe version from device NVRAM.
-func (d *Device) GetProductVer() (value string, err error) {
-	value, err = d.getProperty(PropProductVer)
+func (d *Device) GetProductVer() (string, error) {
+	return d.GetProductVerContext(context.Background())
+}
+
+// GetProductVerContext is GetProductVer with a context.
+func (d *Device) GetProductVerContext(ctx context.Context) (value string, err error) {
+	value, err = d.getProperty(ctx, PropProductVer)
 	if len(value) <= 1 {value = ""}
 	return value, err
 }
 
 // GetDeviceSN retrieves the configurable serial number from device NVRAM.
 func (d *Device) GetDeviceSN() (string, error) {
-	return d.getProperty(PropDeviceSN)
+	return d.GetDeviceSNContext(context.Background())
+}
+
+// GetDeviceSNContext is GetDeviceSN with a context.
+func (d *Device) GetDeviceSNContext(ctx context.Context) (string, error) {
+	return d.getProperty(ctx, PropDeviceSN)
 }
 
-// SetDeviceSN sets the configurable serial number in device NVRAM.
+// SetDeviceSN sets the configurable serial number in device NVRAM. The value
+// is rejected without writing to the device if it violates the serial policy.
 func (d *Device) SetDeviceSN(value string) (error) {
-	return d.setProperty(PropDeviceSN, value)
+	return d.SetDeviceSNContext(context.Background(), value)
+}
+
+// SetDeviceSNContext is SetDeviceSN with a context.
+func (d *Device) SetDeviceSNContext(ctx context.Context, value string) (error) {
+	return d.setDeviceSN(ctx, "SetDeviceSN", value)
 }
 
 // EraseDeviceSN removes the configurable serial number from device NVRAM.
 func (d *Device) EraseDeviceSN() (error) {
-	return d.setProperty(PropDeviceSN, "")
+	return d.EraseDeviceSNContext(context.Background())
+}
+
+// EraseDeviceSNContext is EraseDeviceSN with a context.
+func (d *Device) EraseDeviceSNContext(ctx context.Context) (error) {
+	return d.auditedWrite(ctx, "EraseDeviceSN", PropDeviceSN, "")
 }
 
 // GetFactorySN retrieves the factory serial number from device NVRAM.
-func (d *Device) GetFactorySN() (value string, err error) {
-	value, err = d.getProperty(PropFactorySN)
+func (d *Device) GetFactorySN() (string, error) {
+	return d.GetFactorySNContext(context.Background())
+}
+
+// GetFactorySNContext is GetFactorySN with a context.
+func (d *Device) GetFactorySNContext(ctx context.Context) (value string, err error) {
+	value, err = d.getProperty(ctx, PropFactorySN)
 	if len(value) <= 1 {value = ""}
 	return value, err
 }
 
 // SetFactorySN sets the factory serial number in device NVRAM. This command
 // will fail with result code 07 if the serial number is already configured.
+// Because the write is irreversible, it is refused unless the operation policy
+// sets ForceFactorySN.
 func (d *Device) SetFactorySN(value string) (error) {
-	return d.setProperty(PropFactorySN, value)
+	return d.SetFactorySNContext(context.Background(), value)
+}
+
+// SetFactorySNContext is SetFactorySN with a context.
+func (d *Device) SetFactorySNContext(ctx context.Context, value string) (error) {
+
+	r := d.newAuditRecord(ctx, "SetFactorySN")
+	err := d.OperationPolicy.CheckWrite(PropFactorySN)
+
+	if err == nil {
+		d.writeMutex.Lock()
+		err = d.setProperty(ctx, PropFactorySN, value)
+		d.writeMutex.Unlock()
+	}
+
+	if r != nil {
+		r.withProperty(PropFactorySN, r.FactorySN, value)
+	}
+
+	return d.writeAudit(r, err)
 }
 
 // CopyFactorySN copies 'length' characters from the factory serial
 // number to the configurable serial number in device NVRAM.
 func (d *Device) CopyFactorySN(length int) (error) {
+	return d.CopyFactorySNContext(context.Background(), length)
+}
+
+// CopyFactorySNContext is CopyFactorySN with a context.
+func (d *Device) CopyFactorySNContext(ctx context.Context, length int) (error) {
 
-	fs, err := d.GetFactorySN()
+	fs, err := d.GetFactorySNContext(ctx)
 
 	if err != nil {
-		return fmt.Errorf("%s: %v", getFunctionInfo(), err)
+		return fmt.Errorf("%s: %w", getFunctionInfo(), err)
 	}
 
 	if len(fs) == 0 {
@@ -151,34 +266,52 @@ func (d *Device) CopyFactorySN(length int) (error) {
 	}
 
 	limit := int(math.Min(float64(length), float64(len(fs))))
-	err = d.SetDeviceSN(fs[:limit])
+	err = d.setDeviceSN(ctx, "CopyFactorySN", fs[:limit])
 
 	return err
 }
 
 // GetVendorName retrieves the manufacturer name from device descriptor.
-func (d *Device) GetVendorName() (value string, err error) {
+func (d *Device) GetVendorName() (string, error) {
+	return d.GetVendorNameContext(context.Background())
+}
+
+// GetVendorNameContext is GetVendorName with a context.
+func (d *Device) GetVendorNameContext(ctx context.Context) (value string, err error) {
+
+	if err = d.ensureDescriptors(ctx); err != nil {
+		return value, fmt.Errorf("%s: %w", getFunctionInfo(), err)
+	}
 
 	if d.DeviceDescriptor.ManufacturerIndex > 0 {
-		value, err = d.GetStringDescriptor(int(d.DeviceDescriptor.ManufacturerIndex))
+		value, err = d.stringDescriptor(ctx, d.DeviceDescriptor.ManufacturerIndex)
 	}
 
 	if err != nil {
-		err = fmt.Errorf("%s: %v", getFunctionInfo(), err)
+		err = fmt.Errorf("%s: %w", getFunctionInfo(), err)
 	}
 
 	return value, err
 }
 
 // GetProductName retrieves the product name from device descriptor.
-func (d *Device) GetProductName() (value string, err error) {
+func (d *Device) GetProductName() (string, error) {
+	return d.GetProductNameContext(context.Background())
+}
+
+// GetProductNameContext is GetProductName with a context.
+func (d *Device) GetProductNameContext(ctx context.Context) (value string, err error) {
+
+	if err = d.ensureDescriptors(ctx); err != nil {
+		return value, fmt.Errorf("%s: %w", getFunctionInfo(), err)
+	}
 
 	if d.DeviceDescriptor.ProductIndex > 0 {
-		value, err = d.GetStringDescriptor(int(d.DeviceDescriptor.ProductIndex))
+		value, err = d.stringDescriptor(ctx, d.DeviceDescriptor.ProductIndex)
 	}
 
 	if err != nil {
-		err = fmt.Errorf("%s: %v", getFunctionInfo(), err)
+		err = fmt.Errorf("%s: %w", getFunctionInfo(), err)
 	}
 
 	return value, err
@@ -189,61 +322,90 @@ func (d *Device) GetProductName() (value string, err error) {
 // control transfer are not reflected in the device descriptor until the device
 // is power-cycled (unplugged). The most current information is always stored
 // on the device.
-func (d *Device) GetDescriptSN() (value string, err error) {
+func (d *Device) GetDescriptSN() (string, error) {
+	return d.GetDescriptSNContext(context.Background())
+}
+
+// GetDescriptSNContext is GetDescriptSN with a context.
+func (d *Device) GetDescriptSNContext(ctx context.Context) (value string, err error) {
+
+	if err = d.ensureDescriptors(ctx); err != nil {
+		return value, fmt.Errorf("%s: %w", getFunctionInfo(), err)
+	}
 
 	if d.DeviceDescriptor.SerialNumIndex > 0 {
-		value, err = d.GetStringDescriptor(int(d.DeviceDescriptor.SerialNumIndex))
+		value, err = d.stringDescriptor(ctx, d.DeviceDescriptor.SerialNumIndex)
 	}
 
 	if err != nil {
-		err = fmt.Errorf("%s: %v", getFunctionInfo(), err)
+		err = fmt.Errorf("%s: %w", getFunctionInfo(), err)
 	}
 
 	return value, err
 }
 
 // UsbReset performs a USB port reset to reinitialize the device.
-func (d *Device) UsbReset() (err error) {
-	return d.Reset()
+func (d *Device) UsbReset() (error) {
+	return d.UsbResetContext(context.Background())
 }
 
+// UsbResetContext is UsbReset with a context. The context is checked before
+// the reset starts; the port reset itself cannot be interrupted.
+func (d *Device) UsbResetContext(ctx context.Context) (err error) {
 
-// DeviceReset resets the device using low-level vendor commands.
-func (d *Device) DeviceReset() (err error) {
+	r := d.newAuditRecord(ctx, "UsbReset")
 
-	data := make([]byte, d.BufferSize)
-	data[0] = CommandResetDevice
+	if err = d.OperationPolicy.CheckReset(); err == nil {
+		if !d.usb {
+			err = fmt.Errorf("%s: USB reset not supported by transport", getFunctionInfo())
+		} else if err = ctx.Err(); err == nil {
+			d.cmdMutex.Lock()
+			err = classify(d.Reset())
+			d.cmdMutex.Unlock()
+		}
+	}
 
-	_, err = d.Control(
-		RequestDirectionOut + RequestTypeClass + RequestRecipientDevice,
-		RequestSetReport,
-		TypeFeatureReport,
-		InterfaceNumber,
-		data)
+	return d.writeAudit(r, err)
+}
 
-	if err != nil {
-		err = fmt.Errorf("%s: %v)", getFunctionInfo(), err)
-	}
+// DeviceReset resets the device using low-level vendor commands, then waits
+// ResetDelay for the device to reinitialize.
+func (d *Device) DeviceReset() (error) {
+	return d.DeviceResetContext(context.Background())
+}
 
-	data = make([]byte, d.BufferSize)
+// DeviceResetContext is DeviceReset with a context. Canceling the context
+// cuts the wait for the device to reinitialize short and returns the context
+// error, but does not undo a reset already sent.
+func (d *Device) DeviceResetContext(ctx context.Context) (err error) {
 
-	_, err = d.Control(
-		RequestDirectionIn + RequestTypeClass + RequestRecipientDevice,
-		RequestGetReport,
-		TypeFeatureReport,
-		InterfaceNumber,
-		data)
+	if err = d.deviceReset(ctx); err != nil {
+		return err
+	}
 
-	if err != nil {
-		return fmt.Errorf("%s: %v", getFunctionInfo(), err)
+	select {
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-time.After(d.ResetDelay):
 	}
 
-	if data[0] > 0x00 {
-		err = fmt.Errorf("%s: command error: %d",
-			getFunctionInfo(), int(data[0]))
+	return err
+}
+
+// deviceReset sends the reset command without waiting for the device to
+// reinitialize.
+func (d *Device) deviceReset(ctx context.Context) (err error) {
+
+	r := d.newAuditRecord(ctx, "DeviceReset")
+	defer func() {err = d.writeAudit(r, err)}()
+
+	if err = d.OperationPolicy.CheckReset(); err != nil {
+		return err
 	}
 
-	time.Sleep(5 * time.Second)
+	if _, err = d.commandPolicy(ctx, nil, []byte{CommandResetDevice}); err != nil {
+		err = fmt.Errorf("%s: %w", getFunctionInfo(), err)
+	}
 
 	return err
 }
@@ -253,7 +415,7 @@ func (d *Device) getDeviceDescriptor() (err error) {
 
 	data := make([]byte, BufferSizeDeviceDescriptor)
 
-	_, err = d.Control(
+	_, err = d.control(context.Background(),
 		RequestDirectionIn + RequestTypeStandard + RequestRecipientDevice,
 		RequestGetDescriptor,
 		TypeDeviceDescriptor,
@@ -278,7 +440,7 @@ func (d *Device) getDeviceDescriptor() (err error) {
 			data[16],
 			data[17]}
 	} else {
-		err = fmt.Errorf("%s: %v", getFunctionInfo(), err)
+		err = fmt.Errorf("%s: %w", getFunctionInfo(), err)
 	}
 
 	return err
@@ -289,7 +451,7 @@ func (d *Device) getConfigDescriptor() (err error) {
 
 	data := make([]byte, BufferSizeConfigDescriptor)
 
-	_, err = d.Control(
+	_, err = d.control(context.Background(),
 		RequestDirectionIn + RequestTypeStandard + RequestRecipientDevice,
 		RequestGetDescriptor,
 		TypeConfigDescriptor,
@@ -308,7 +470,7 @@ func (d *Device) getConfigDescriptor() (err error) {
 			data[7],
 			data[8]}
 	} else {
-		return fmt.Errorf("%s: %v", getFunctionInfo(), err)
+		return fmt.Errorf("%s: %w", getFunctionInfo(), err)
 	}
 
 	return err
@@ -324,32 +486,20 @@ func (d *Device) findBufferSize() (err error) {
 
 	for _, size = range vendorBufferSizes {
 
-		data := make([]byte, size)
-		copy(data, []byte{CommandGetProp, 0x01, PropSoftwareID})
-
-		rc, err = d.Control(
-			RequestDirectionOut + RequestTypeClass + RequestRecipientDevice,
-			RequestSetReport,
-			TypeFeatureReport,
-			InterfaceNumber,
-			data)
-
-		data = make([]byte, size)
+		out := make([]byte, size)
+		copy(out, []byte{CommandGetProp, 0x01, PropSoftwareID})
 
-		rc, err = d.Control(
-			RequestDirectionIn + RequestTypeClass + RequestRecipientDevice,
-			RequestGetReport,
-			TypeFeatureReport,
-			InterfaceNumber,
-			data)
+		rc, err = d.exchange(context.Background(), out, make([]byte, size))
 
 		if rc == size {
 			d.BufferSize = size
-			break
+			return nil
 		}
 	}
 
 	if err != nil {
+		err = fmt.Errorf("%s: unsupported device: %w", getFunctionInfo(), err)
+	} else {
 		err = fmt.Errorf("%s: unsupported device", getFunctionInfo())
 	}
 
@@ -357,86 +507,111 @@ func (d *Device) findBufferSize() (err error) {
 }
 
 // getProperty retrieves a property from device NVRAM using low-level commands.
-func (d *Device) getProperty(id uint8) (value string, err error) {
-
-	data := make([]byte, d.BufferSize)
-	copy(data, []byte{CommandGetProp, 0x01, id})
+func (d *Device) getProperty(ctx context.Context, id uint8) (value string, err error) {
 
-	_, err = d.Control(
-		RequestDirectionOut + RequestTypeClass + RequestRecipientDevice,
-		RequestSetReport,
-		TypeFeatureReport,
-		InterfaceNumber,
-		data)
+	data, err := d.command(ctx, []byte{CommandGetProp, 0x01, id})
 
 	if err != nil {
-		return value, fmt.Errorf("%s: %v", getFunctionInfo(), err)
+		return value, fmt.Errorf("%s: %w", getFunctionInfo(), err)
 	}
 
-	data = make([]byte, d.BufferSize)
+	if data[1] > 0x00 {
+		value = string(data[2:int(data[1])+2])
+	}
 
-	_, err = d.Control(
-		RequestDirectionIn + RequestTypeClass + RequestRecipientDevice,
-		RequestGetReport,
-		TypeFeatureReport,
-		InterfaceNumber,
-		data)
+	return value, err
+}
 
-	if err != nil {
-		return value, fmt.Errorf("%s: %v", getFunctionInfo(), err)
+// setDeviceSN validates the serial number against the serial policy, then
+// writes it to device NVRAM on behalf of the named operation. An empty value
+// erases the serial number and, as with EraseDeviceSN, is not validated.
+func (d *Device) setDeviceSN(ctx context.Context, op, value string) (error) {
+
+	if d.SerialPolicy != nil && len(value) > 0 {
+		if err := d.SerialPolicy.Validate(value); err != nil {
+			return err
+		}
+	}
+
+	return d.auditedWrite(ctx, op, PropDeviceSN, value)
+}
+
+// auditedWrite performs a verified property write on behalf of the named
+// operation, if the operation policy allows it, and records it to the audit
+// sink.
+func (d *Device) auditedWrite(ctx context.Context, op string, id uint8, value string) (error) {
+
+	r := d.newAuditRecord(ctx, op)
+
+	if err := d.OperationPolicy.CheckWrite(id); err != nil {
+		return d.writeAudit(r.withProperty(id, "", value), err)
 	}
 
-	if data[0] > 0x00 {
-		return value, fmt.Errorf("%s: command error: %d",
-			getFunctionInfo(), int(data[0]))
+	old, err := d.writeProperty(ctx, id, value)
+
+	return d.writeAudit(r.withProperty(id, old, value), err)
+}
+
+// writeProperty configures a property in device NVRAM and verifies the
+// result by reading it back. If the value read back does not match, the
+// previous value is restored and a *PropertyWriteError describing the old,
+// new, and read-back values is returned. The previous value is returned in
+// either case. The rollback is attempted even if the context is done, so
+// that a canceled write does not leave a half-applied value behind.
+func (d *Device) writeProperty(ctx context.Context, id uint8, value string) (old string, err error) {
+
+	d.writeMutex.Lock()
+	defer d.writeMutex.Unlock()
+
+	if old, err = d.getProperty(ctx, id); err != nil {
+		return old, fmt.Errorf("%s: %w", getFunctionInfo(), err)
 	}
 
-	if data[1] > 0x00 {
-		value = string(data[2:int(data[1])+2])
+	err = d.setProperty(ctx, id, value)
+	read, rerr := d.getProperty(ctx, id)
+
+	if err == nil && rerr == nil && read == value {
+		return old, nil
 	}
 
-	return value, err
+	pwe := &PropertyWriteError{ID: id, Old: old, New: value, Read: read, Err: err}
+
+	if pwe.Err == nil {
+		pwe.Err = rerr
+	}
+
+	if rerr != nil || read != old {
+		pwe.RollbackErr = d.setProperty(context.Background(), id, old)
+		pwe.RolledBack = true
+	}
+
+	return old, pwe
 }
 
 // setProperty configures a property in device NVRAM using low-level commands.
-func (d *Device) setProperty(id uint8, value string) (err error) {
+func (d *Device) setProperty(ctx context.Context, id uint8, value string) (err error) {
 
 	if len(value) > d.BufferSize - 3 {
 		return fmt.Errorf("%s: property length > data buffer", getFunctionInfo())
 	}
 
-	data := make([]byte, d.BufferSize)
-	copy(data[0:], []byte{CommandSetProp, uint8(len(value)+1), id})
-	copy(data[3:], value)
+	cmd := append([]byte{CommandSetProp, uint8(len(value)+1), id}, value...)
 
-	_, err = d.Control(
-		RequestDirectionOut + RequestTypeClass + RequestRecipientDevice,
-		RequestSetReport,
-		TypeFeatureReport,
-		InterfaceNumber,
-		data)
-
-	if err != nil {
-		return fmt.Errorf("%s: %v", getFunctionInfo(), err)
+	if _, err = d.command(ctx, cmd); err != nil {
+		err = fmt.Errorf("%s: %w", getFunctionInfo(), err)
 	}
 
-	data = make([]byte, d.BufferSize)
+	return err
+}
 
-	_, err = d.Control(
-		RequestDirectionIn + RequestTypeClass + RequestRecipientDevice,
-		RequestGetReport,
-		TypeFeatureReport,
-		InterfaceNumber,
-		data)
+// portPath formats the port path of a device descriptor.
+func portPath(desc *gousb.DeviceDesc) string {
 
-	if err != nil {
-		return fmt.Errorf("%s: %v", getFunctionInfo(), err)
-	}
+	var ports []string
 
-	if data[0] > 0x00 {
-		err = fmt.Errorf("%s: command error: %d",
-			getFunctionInfo(), int(data[0]))
+	for _, p := range desc.Path {
+		ports = append(ports, strconv.Itoa(p))
 	}
 
-	return err
+	return fmt.Sprintf("%d-%s", desc.Bus, strings.Join(ports, "."))
 }
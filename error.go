@@ -1,11 +1,114 @@
 package gomagtek
 
 import (
+	"github.com/google/gousb"
 	"path/filepath"
 	"runtime"
+	"errors"
 	"fmt"
 )
 
+// Error categories. USB errors returned by Device methods match one of these
+// with errors.Is when they fall into the category, and still match the
+// underlying gousb error as well.
+var (
+	ErrAccess = errors.New("access denied")
+	ErrNotFound = errors.New("device not found")
+	ErrBusy = errors.New("device busy")
+	ErrPipe = errors.New("pipe error")
+	ErrTimeout = errors.New("timed out")
+)
+
+// usbError attaches a category to a gousb error.
+type usbError struct {
+	err error
+	category error
+}
+
+func (e *usbError) Error() string {
+	return e.err.Error()
+}
+
+func (e *usbError) Unwrap() error {
+	return e.err
+}
+
+func (e *usbError) Is(target error) bool {
+	return target == e.category
+}
+
+// classify wraps a gousb error with its category. Other errors, and gousb
+// errors that fall into no category, are returned unchanged.
+func classify(err error) error {
+
+	var category error
+
+	switch err {
+
+	case gousb.ErrorAccess:
+		category = ErrAccess
+
+	case gousb.ErrorNotFound, gousb.ErrorNoDevice, gousb.TransferNoDevice:
+		category = ErrNotFound
+
+	case gousb.ErrorBusy:
+		category = ErrBusy
+
+	case gousb.ErrorPipe, gousb.TransferStall:
+		category = ErrPipe
+
+	case gousb.ErrorTimeout, gousb.TransferTimedOut:
+		category = ErrTimeout
+
+	default:
+		return err
+	}
+
+	return &usbError{err, category}
+}
+
+// PropertyWriteError reports a device NVRAM property write that failed or
+// could not be verified by reading the property back. RolledBack indicates
+// whether the previous value was rewritten, and RollbackErr holds the error,
+// if any, from that attempt.
+type PropertyWriteError struct {
+	ID uint8
+	Old string
+	New string
+	Read string
+	Err error
+	RolledBack bool
+	RollbackErr error
+}
+
+func (e *PropertyWriteError) Error() (s string) {
+
+	s = fmt.Sprintf("property %d write %q failed: old %q, read back %q",
+		e.ID, e.New, e.Old, e.Read)
+
+	if e.Err != nil {
+		s += fmt.Sprintf(": %v", e.Err)
+	}
+
+	switch {
+
+	case !e.RolledBack:
+		s += "; no rollback needed"
+
+	case e.RollbackErr != nil:
+		s += fmt.Sprintf("; rollback failed: %v", e.RollbackErr)
+
+	default:
+		s += "; rolled back"
+	}
+
+	return s
+}
+
+func (e *PropertyWriteError) Unwrap() error {
+	return e.Err
+}
+
 // getFunctionInfo returns function filename, line number, and function name
 // for error reporting.
 func getFunctionInfo() string {
@@ -0,0 +1,171 @@
+package gomagtek
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+	"fmt"
+)
+
+const DefaultFleetConcurrency int = 4
+
+// FleetFunc is an operation run against a single device by a Fleet. It may
+// return arbitrary output, such as a report, along with an error. It should
+// use the context for every device call, so that it stops when the fleet
+// timeout for the device expires.
+type FleetFunc func(ctx context.Context, d *Device) (interface{}, error)
+
+// FleetResult holds the outcome of a fleet operation on one device.
+type FleetResult struct {
+	Device *Device
+	BusNumber string
+	BusAddress string
+	ProductID string
+	Output interface{}
+	Error error
+	TimedOut bool
+	Duration time.Duration
+}
+
+// Fleet runs an operation across many devices at once. Concurrency bounds
+// the number of devices operated on at the same time, and Timeout, if set,
+// bounds the time spent on each device through the context passed to the
+// operation. An operation keeps its place until it returns, even after its
+// timeout expires, so Concurrency is never exceeded. Only devices for which
+// Match returns true are included; a nil Match includes every device. A
+// failure on one device never prevents the operation from running on the
+// others.
+type Fleet struct {
+	Concurrency int
+	Timeout time.Duration
+	Match func(*Device) bool
+}
+
+// NewFleet constructs a new Fleet.
+func NewFleet(concurrency int, timeout time.Duration) (*Fleet) {
+
+	if concurrency <= 0 {
+		concurrency = DefaultFleetConcurrency
+	}
+
+	return &Fleet{Concurrency: concurrency, Timeout: timeout}
+}
+
+// Run performs the operation on every matching device and returns one result
+// per matching device, in the order the devices were given. It returns only
+// after the operation has returned on every device.
+func (f *Fleet) Run(devices []*Device, fn FleetFunc) ([]FleetResult) {
+	return f.RunContext(context.Background(), devices, fn)
+}
+
+// RunContext is Run with a context. The context of each operation is derived
+// from it.
+func (f *Fleet) RunContext(ctx context.Context, devices []*Device, fn FleetFunc) (rs []FleetResult) {
+
+	var matched []*Device
+
+	for _, d := range devices {
+		if f.Match == nil || f.Match(d) {
+			matched = append(matched, d)
+		}
+	}
+
+	n := f.Concurrency
+
+	if n <= 0 {
+		n = DefaultFleetConcurrency
+	}
+
+	rs = make([]FleetResult, len(matched))
+	sem := make(chan struct{}, n)
+
+	var wg sync.WaitGroup
+
+	for i, d := range matched {
+
+		wg.Add(1)
+
+		go func(i int, d *Device) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() {<-sem}()
+			rs[i] = f.runOne(ctx, d, fn)
+		}(i, d)
+	}
+
+	wg.Wait()
+
+	return rs
+}
+
+// runOne performs the operation on a single device with the fleet timeout,
+// if any. A failure after the timeout expired is reported as timed out.
+func (f *Fleet) runOne(ctx context.Context, d *Device, fn FleetFunc) (r FleetResult) {
+
+	r = FleetResult {
+		Device: d,
+		BusNumber: d.GetBusNumber(),
+		BusAddress: d.GetBusAddress(),
+		ProductID: d.GetProductID()}
+
+	var cancel context.CancelFunc
+
+	if f.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, f.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+
+	defer cancel()
+
+	start := time.Now()
+	r.Output, r.Error = safeCall(ctx, fn, d)
+	r.Duration = time.Since(start)
+
+	if r.Error != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		r.TimedOut = true
+		r.Error = fmt.Errorf("%s: operation timed out after %v: %w", getFunctionInfo(), f.Timeout, r.Error)
+	}
+
+	return r
+}
+
+// safeCall runs the operation, converting a panic into an error so that one
+// misbehaving device cannot bring down the whole fleet.
+func safeCall(ctx context.Context, fn FleetFunc, d *Device) (out interface{}, err error) {
+
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("%s: panic: %v", getFunctionInfo(), p)
+		}
+	}()
+
+	return fn(ctx, d)
+}
+
+// FleetReport returns a FleetFunc that produces a Report for each device.
+func FleetReport(fields []string) (FleetFunc) {
+	return func(ctx context.Context, d *Device) (interface{}, error) {
+		return d.ReportContext(ctx, fields)
+	}
+}
+
+// FleetApply returns a FleetFunc that brings each device into the desired
+// state, such as a device serial number rule, and outputs the changes made.
+func FleetApply(ds *DesiredState) (FleetFunc) {
+	return func(ctx context.Context, d *Device) (interface{}, error) {
+		return d.ApplyContext(ctx, ds)
+	}
+}
+
+// FleetReset returns a FleetFunc that resets each device, using a USB port
+// reset if usb is true or a device reset otherwise.
+func FleetReset(usb bool) (FleetFunc) {
+	return func(ctx context.Context, d *Device) (interface{}, error) {
+		if usb {
+			return nil, d.UsbResetContext(ctx)
+		}
+		return nil, d.DeviceResetContext(ctx)
+	}
+}
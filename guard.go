@@ -0,0 +1,56 @@
+package gomagtek
+
+import "fmt"
+
+// OperationPolicy restricts the changes a process may make to a device.
+// ReadOnly blocks every NVRAM write and reset. ForceFactorySN must be set to
+// write the factory serial number, which can only be written once. When
+// AllowProperties is not empty, only the listed properties may be written.
+// A Device without a policy allows every operation except factory serial
+// number writes.
+type OperationPolicy struct {
+	ReadOnly bool
+	ForceFactorySN bool
+	AllowProperties []uint8
+}
+
+// CheckWrite returns an error if the policy forbids writing the property.
+func (op *OperationPolicy) CheckWrite(id uint8) (err error) {
+
+	if op == nil {
+		if id == PropFactorySN {
+			err = fmt.Errorf("%s: factory serial number write requires force", getFunctionInfo())
+		}
+		return err
+	}
+
+	if op.ReadOnly {
+		return fmt.Errorf("%s: property 0x%02X write blocked: read-only mode", getFunctionInfo(), id)
+	}
+
+	if id == PropFactorySN && !op.ForceFactorySN {
+		return fmt.Errorf("%s: factory serial number write requires force", getFunctionInfo())
+	}
+
+	if len(op.AllowProperties) == 0 {
+		return err
+	}
+
+	for _, a := range op.AllowProperties {
+		if a == id {
+			return err
+		}
+	}
+
+	return fmt.Errorf("%s: property 0x%02X write blocked: not in allowlist", getFunctionInfo(), id)
+}
+
+// CheckReset returns an error if the policy forbids resetting the device.
+func (op *OperationPolicy) CheckReset() (err error) {
+
+	if op != nil && op.ReadOnly {
+		err = fmt.Errorf("%s: reset blocked: read-only mode", getFunctionInfo())
+	}
+
+	return err
+}
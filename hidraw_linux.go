@@ -0,0 +1,398 @@
+//go:build linux
+// +build linux
+
+package gomagtek
+
+import (
+	"github.com/google/gousb"
+	"path/filepath"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+	"fmt"
+	"os"
+)
+
+// FeatureDevice exchanges HID feature reports with a device. The first byte
+// of the buffer is the report ID, followed by the report data; both calls
+// return the number of bytes transferred, including the report ID.
+type FeatureDevice interface {
+	SetFeature(buf []byte) (int, error)
+	GetFeature(buf []byte) (int, error)
+	Close() error
+}
+
+// HidrawTransport carries the vendor commands of a Device over a Linux hidraw
+// node instead of libusb. Feature reports are exchanged with the
+// HIDIOCSFEATURE and HIDIOCGFEATURE ioctls, so the usbhid kernel driver stays
+// attached and card swipes keep flowing to applications while properties are
+// read and written. Descriptors are read from sysfs. Path is the hidraw node
+// and SysfsDir the sysfs directory of the USB device. Feature, if nil, is
+// opened from Path when the transport is first used by NewHidrawDevice.
+type HidrawTransport struct {
+	Path string
+	SysfsDir string
+	Feature FeatureDevice
+	descriptors []byte
+}
+
+// FindHidraw finds the hidraw nodes of Magtek readers through sysfs without
+// opening them. The sysfs and dev arguments are where sysfs and device nodes
+// are found, normally "/sys" and "/dev". Only the first hidraw node of each
+// USB device is returned.
+func FindHidraw(sysfs, dev string) (ts []*HidrawTransport, err error) {
+
+	dirs, err := filepath.Glob(filepath.Join(sysfs, "class", "hidraw", "hidraw*"))
+
+	if err != nil {
+		return ts, fmt.Errorf("%s: %w", getFunctionInfo(), err)
+	}
+
+	seen := make(map[string]bool)
+
+	for _, dir := range dirs {
+
+		hid, err := filepath.EvalSymlinks(filepath.Join(dir, "device"))
+
+		if err != nil {
+			continue
+		}
+
+		// The HID device is a child of the USB interface, which is a
+		// child of the USB device.
+
+		usb := filepath.Dir(filepath.Dir(hid))
+
+		if vid, _ := readSysfs(usb, "idVendor"); vid != fmt.Sprintf("%04x", MagtekVendorID) || seen[usb] {
+			continue
+		}
+
+		seen[usb] = true
+
+		ts = append(ts, &HidrawTransport {
+			Path: filepath.Join(dev, filepath.Base(dir)),
+			SysfsDir: usb})
+	}
+
+	return ts, nil
+}
+
+// OpenHidrawDevices opens every Magtek reader found by FindHidraw and
+// constructs a Device for each with the options, reporting those that could
+// not be opened with an *OpenError.
+func OpenHidrawDevices(sysfs, dev string, opts ...DeviceOption) (devices []*Device, errs []error) {
+
+	ts, err := FindHidraw(sysfs, dev)
+
+	if err != nil {
+		return devices, []error{err}
+	}
+
+	for _, t := range ts {
+
+		d, err := NewHidrawDevice(t, opts...)
+
+		if err != nil {
+			desc, _ := t.DeviceDesc()
+			errs = append(errs, newOpenError(desc, err))
+			continue
+		}
+
+		devices = append(devices, d)
+	}
+
+	return devices, errs
+}
+
+// NewHidrawDevice constructs a new Device that uses the hidraw transport,
+// with the same options as NewDevice. USB port resets and reopening after a
+// reset are not available on such a Device.
+func NewHidrawDevice(t *HidrawTransport, opts ...DeviceOption) (nd *Device, err error) {
+
+	desc, err := t.DeviceDesc()
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", getFunctionInfo(), err)
+	}
+
+	// Assign the node only once it is open, so that a failed open leaves
+	// Feature nil rather than holding a nil *hidrawFile.
+
+	if t.Feature == nil {
+
+		f, err := openHidraw(t.Path)
+
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", getFunctionInfo(), err)
+		}
+
+		t.Feature = f
+	}
+
+	nd = &Device {
+		Device: &gousb.Device{Desc: desc},
+		DeviceDescriptor: new(DeviceDescriptor),
+		ConfigDescriptor: new(ConfigDescriptor),
+		ResetDelay: DefaultResetDelay,
+		RetryPolicy: NewRetryPolicy(),
+		Transport: t}
+
+	if err = nd.open(opts); err != nil {
+		t.Close()
+		return nil, err
+	}
+
+	return nd, err
+}
+
+// DeviceDesc builds the gousb device description of the USB device from its
+// sysfs attributes.
+func (t *HidrawTransport) DeviceDesc() (desc *gousb.DeviceDesc, err error) {
+
+	desc = new(gousb.DeviceDesc)
+
+	// The sysfs directory is named after the port path, e.g. "1-2.4".
+
+	if parts := strings.SplitN(filepath.Base(t.SysfsDir), "-", 2); len(parts) == 2 {
+		for _, p := range strings.Split(parts[1], ".") {
+			if n, err := strconv.Atoi(p); err == nil {
+				desc.Path = append(desc.Path, n)
+				desc.Port = n
+			}
+		}
+	}
+
+	ints := map[string]*int {
+		"busnum": &desc.Bus,
+		"devnum": &desc.Address,
+		"bMaxPacketSize0": &desc.MaxControlPacketSize}
+
+	for attr, p := range ints {
+		s, err := readSysfs(t.SysfsDir, attr)
+		if err != nil {
+			return desc, fmt.Errorf("%s: %w", getFunctionInfo(), err)
+		}
+		if *p, err = strconv.Atoi(s); err != nil {
+			return desc, fmt.Errorf("%s: %s: %w", getFunctionInfo(), attr, err)
+		}
+	}
+
+	hexAttrs := map[string]func(uint64) {
+		"idVendor": func(v uint64) {desc.Vendor = gousb.ID(v)},
+		"idProduct": func(v uint64) {desc.Product = gousb.ID(v)},
+		"bcdDevice": func(v uint64) {desc.Device = gousb.BCD(v)},
+		"bDeviceClass": func(v uint64) {desc.Class = gousb.Class(v)},
+		"bDeviceSubClass": func(v uint64) {desc.SubClass = gousb.Class(v)},
+		"bDeviceProtocol": func(v uint64) {desc.Protocol = gousb.Protocol(v)}}
+
+	for attr, set := range hexAttrs {
+		if s, err := readSysfs(t.SysfsDir, attr); err == nil {
+			if v, err := strconv.ParseUint(s, 16, 16); err == nil {
+				set(v)
+			}
+		}
+	}
+
+	// The version attribute holds the bcdUSB bytes in hex, e.g. "1.10"
+	// for 0x0110, so they make up the BCD value as they are.
+
+	if s, err := readSysfs(t.SysfsDir, "version"); err == nil {
+		var major, minor uint8
+		if _, err := fmt.Sscanf(s, "%x.%x", &major, &minor); err == nil {
+			desc.Spec = gousb.BCD(uint16(major) << 8 | uint16(minor))
+		}
+	}
+
+	speeds := map[string]gousb.Speed {
+		"1.5": gousb.SpeedLow,
+		"12": gousb.SpeedFull,
+		"480": gousb.SpeedHigh,
+		"5000": gousb.SpeedSuper}
+
+	if s, err := readSysfs(t.SysfsDir, "speed"); err == nil {
+		desc.Speed = speeds[s]
+	}
+
+	return desc, nil
+}
+
+// Control performs a control transfer over hidraw. Feature report transfers
+// become feature report ioctls, and device and configuration descriptor
+// requests are answered from sysfs; other requests are not supported.
+func (t *HidrawTransport) Control(rType, request uint8, val, idx uint16, data []byte) (n int, err error) {
+
+	switch {
+
+	case request == RequestSetReport && val & 0xFF00 == TypeFeatureReport:
+
+		buf := append([]byte{uint8(val)}, data...)
+
+		if n, err = t.Feature.SetFeature(buf); n > 0 {
+			n--
+		}
+
+		return n, err
+
+	case request == RequestGetReport && val & 0xFF00 == TypeFeatureReport:
+
+		buf := make([]byte, len(data) + 1)
+		buf[0] = uint8(val)
+
+		if n, err = t.Feature.GetFeature(buf); n > 0 {
+			n--
+			copy(data, buf[1:n+1])
+		}
+
+		return n, err
+
+	case request == RequestGetDescriptor:
+
+		desc, err := t.sysfsDescriptors()
+
+		if err != nil {
+			return 0, err
+		}
+
+		switch val & 0xFF00 {
+
+		case TypeDeviceDescriptor:
+			return copy(data, desc), nil
+
+		case TypeConfigDescriptor:
+			if len(desc) > BufferSizeDeviceDescriptor {
+				return copy(data, desc[BufferSizeDeviceDescriptor:]), nil
+			}
+		}
+	}
+
+	return 0, gousb.ErrorNotSupported
+}
+
+// GetStringDescriptor retrieves the manufacturer, product, or serial number
+// string from sysfs, according to which of them the index refers to in the
+// device descriptor.
+func (t *HidrawTransport) GetStringDescriptor(idx int) (string, error) {
+
+	desc, err := t.sysfsDescriptors()
+
+	if err != nil {
+		return "", err
+	}
+
+	if idx > 0 && len(desc) >= BufferSizeDeviceDescriptor {
+
+		switch uint8(idx) {
+
+		case desc[14]:
+			return readSysfs(t.SysfsDir, "manufacturer")
+
+		case desc[15]:
+			return readSysfs(t.SysfsDir, "product")
+
+		case desc[16]:
+			return readSysfs(t.SysfsDir, "serial")
+		}
+	}
+
+	return "", gousb.ErrorNotFound
+}
+
+// Close closes the hidraw node.
+func (t *HidrawTransport) Close() (err error) {
+
+	if t.Feature != nil {
+		err = t.Feature.Close()
+		t.Feature = nil
+	}
+
+	return err
+}
+
+// sysfsDescriptors returns the raw device descriptor followed by the active
+// configuration descriptors, as provided by sysfs.
+func (t *HidrawTransport) sysfsDescriptors() (desc []byte, err error) {
+
+	if t.descriptors == nil {
+		if t.descriptors, err = ioutil.ReadFile(filepath.Join(t.SysfsDir, "descriptors")); err != nil {
+			return nil, fmt.Errorf("%s: %w", getFunctionInfo(), err)
+		}
+	}
+
+	return t.descriptors, nil
+}
+
+// hidrawFile is a FeatureDevice backed by a hidraw node.
+type hidrawFile struct {
+	*os.File
+}
+
+// openHidraw opens a hidraw node for feature report exchange.
+func openHidraw(path string) (*hidrawFile, error) {
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+
+	if err != nil {
+		return nil, classify(classifyErrno(err))
+	}
+
+	return &hidrawFile{f}, nil
+}
+
+// Linux hidraw ioctl request codes: _IOC(_IOC_READ|_IOC_WRITE, 'H', nr, len).
+func hidiocFeature(nr, size int) uintptr {
+	return uintptr(3 << 30 | size << 16 | 'H' << 8 | nr)
+}
+
+func (h *hidrawFile) SetFeature(buf []byte) (int, error) {
+	return h.ioctl(hidiocFeature(0x06, len(buf)), buf)
+}
+
+func (h *hidrawFile) GetFeature(buf []byte) (int, error) {
+	return h.ioctl(hidiocFeature(0x07, len(buf)), buf)
+}
+
+func (h *hidrawFile) ioctl(req uintptr, buf []byte) (int, error) {
+
+	n, _, errno := syscall.Syscall(syscall.SYS_IOCTL, h.Fd(), req, uintptr(unsafe.Pointer(&buf[0])))
+
+	if errno != 0 {
+		return 0, classifyErrno(errno)
+	}
+
+	return int(n), nil
+}
+
+// classifyErrno maps system errors from hidraw to the matching gousb errors,
+// so that they fall into the same categories as errors from libusb.
+func classifyErrno(err error) error {
+
+	var errno syscall.Errno
+
+	if pe, ok := err.(*os.PathError); ok {
+		errno, _ = pe.Err.(syscall.Errno)
+	} else {
+		errno, _ = err.(syscall.Errno)
+	}
+
+	switch errno {
+
+	case syscall.EACCES, syscall.EPERM:
+		return gousb.ErrorAccess
+
+	case syscall.ENOENT, syscall.ENODEV:
+		return gousb.ErrorNoDevice
+
+	case syscall.EBUSY:
+		return gousb.ErrorBusy
+
+	case syscall.EPIPE:
+		return gousb.ErrorPipe
+
+	case syscall.ETIMEDOUT:
+		return gousb.ErrorTimeout
+	}
+
+	return err
+}
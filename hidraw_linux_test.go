@@ -0,0 +1,210 @@
+//go:build linux
+// +build linux
+
+package gomagtek
+
+import (
+	"github.com/google/gousb"
+	"path/filepath"
+	"io/ioutil"
+	"testing"
+	"bytes"
+	"os"
+)
+
+// fakeFeature records the feature reports written to it and answers reads
+// with a fixed report.
+type fakeFeature struct {
+	set []byte
+	get []byte
+}
+
+func (f *fakeFeature) SetFeature(buf []byte) (int, error) {
+	f.set = append([]byte(nil), buf...)
+	return len(buf), nil
+}
+
+func (f *fakeFeature) GetFeature(buf []byte) (int, error) {
+	f.get = append([]byte(nil), buf...)
+	copy(buf[1:], []byte{ResultCodeSuccess, 0x03, 'A', 'B', 'C'})
+	return len(buf), nil
+}
+
+func (f *fakeFeature) Close() error {
+	return nil
+}
+
+// fakeSysfs builds a sysfs tree under root with a hidraw node for a USB
+// device at port 1-2 with the given vendor ID, and returns the directory of
+// the USB device.
+func fakeSysfs(t *testing.T, root, node, port, vid string) (usb string) {
+
+	usb = filepath.Join(root, "devices", "pci0000:00", "usb1", port)
+	hid := filepath.Join(usb, port + ":1.0", "0003:" + vid + ":0011.0001")
+
+	if err := os.MkdirAll(hid, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	attrs := map[string]string {
+		"idVendor": vid,
+		"idProduct": "0011",
+		"bcdDevice": "0100",
+		"bDeviceClass": "00",
+		"busnum": "1",
+		"devnum": "5",
+		"bMaxPacketSize0": "8",
+		"version": " 1.10",
+		"speed": "12",
+		"product": "USB Swipe Reader"}
+
+	for attr, v := range attrs {
+		if err := ioutil.WriteFile(filepath.Join(usb, attr), []byte(v + "\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	class := filepath.Join(root, "class", "hidraw", node)
+
+	if err := os.MkdirAll(class, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink(hid, filepath.Join(class, "device")); err != nil {
+		t.Fatal(err)
+	}
+
+	return usb
+}
+
+func TestFindHidraw(t *testing.T) {
+
+	root := t.TempDir()
+	usb := fakeSysfs(t, root, "hidraw0", "1-2", "0801")
+	fakeSysfs(t, root, "hidraw1", "1-3", "046d")
+
+	ts, err := FindHidraw(root, "/dev")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ts) != 1 {
+		t.Fatalf("FindHidraw() found %d devices; want 1", len(ts))
+	}
+
+	if ts[0].Path != "/dev/hidraw0" || ts[0].SysfsDir != usb {
+		t.Errorf("FindHidraw() = {%s %s}; want {/dev/hidraw0 %s}", ts[0].Path, ts[0].SysfsDir, usb)
+	}
+}
+
+func TestHidrawDeviceDesc(t *testing.T) {
+
+	root := t.TempDir()
+	tr := &HidrawTransport{SysfsDir: fakeSysfs(t, root, "hidraw0", "1-2", "0801")}
+
+	desc, err := tr.DeviceDesc()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &gousb.DeviceDesc {
+		Bus: 1,
+		Address: 5,
+		Port: 2,
+		Path: []int{2},
+		Speed: gousb.SpeedFull,
+		Spec: gousb.BCD(0x0110),
+		Device: gousb.BCD(0x0100),
+		Vendor: gousb.ID(MagtekVendorID),
+		Product: gousb.ID(MagnesafeSwipeHidPID),
+		MaxControlPacketSize: 8}
+
+	switch {
+
+	case desc.Bus != want.Bus, desc.Address != want.Address, desc.Port != want.Port:
+		t.Errorf("DeviceDesc() bus %d address %d port %d; want %d, %d, %d",
+			desc.Bus, desc.Address, desc.Port, want.Bus, want.Address, want.Port)
+
+	case len(desc.Path) != 1 || desc.Path[0] != want.Path[0]:
+		t.Errorf("DeviceDesc() path %v; want %v", desc.Path, want.Path)
+
+	case desc.Speed != want.Speed:
+		t.Errorf("DeviceDesc() speed %v; want %v", desc.Speed, want.Speed)
+
+	case desc.Spec != want.Spec:
+		t.Errorf("DeviceDesc() spec %#04x; want %#04x", uint16(desc.Spec), uint16(want.Spec))
+
+	case desc.Device != want.Device:
+		t.Errorf("DeviceDesc() device %#04x; want %#04x", uint16(desc.Device), uint16(want.Device))
+
+	case desc.Vendor != want.Vendor, desc.Product != want.Product:
+		t.Errorf("DeviceDesc() %v:%v; want %v:%v", desc.Vendor, desc.Product, want.Vendor, want.Product)
+
+	case desc.MaxControlPacketSize != want.MaxControlPacketSize:
+		t.Errorf("DeviceDesc() max packet size %d; want %d",
+			desc.MaxControlPacketSize, want.MaxControlPacketSize)
+	}
+}
+
+func TestHidrawControl(t *testing.T) {
+
+	ff := new(fakeFeature)
+	tr := &HidrawTransport{Feature: ff}
+
+	// SET_REPORT sends the data behind the report ID.
+
+	out := []byte{CommandGetProp, 0x01, PropDeviceSN}
+
+	n, err := tr.Control(RequestDirectionOut + RequestTypeClass + RequestRecipientDevice,
+		RequestSetReport, TypeFeatureReport, InterfaceNumber, out)
+
+	if err != nil || n != len(out) {
+		t.Fatalf("Control(SET_REPORT) = %d, %v; want %d, nil", n, err, len(out))
+	}
+
+	if want := append([]byte{0x00}, out...); !bytes.Equal(ff.set, want) {
+		t.Errorf("SetFeature() got % x; want % x", ff.set, want)
+	}
+
+	// GET_REPORT returns the data without the report ID.
+
+	in := make([]byte, BufferSizeSureswipe)
+
+	n, err = tr.Control(RequestDirectionIn + RequestTypeClass + RequestRecipientDevice,
+		RequestGetReport, TypeFeatureReport, InterfaceNumber, in)
+
+	if err != nil || n != len(in) {
+		t.Fatalf("Control(GET_REPORT) = %d, %v; want %d, nil", n, err, len(in))
+	}
+
+	if len(ff.get) != len(in) + 1 || ff.get[0] != 0x00 {
+		t.Errorf("GetFeature() got %d bytes with report ID %d; want %d bytes with report ID 0",
+			len(ff.get), ff.get[0], len(in) + 1)
+	}
+
+	if want := []byte{ResultCodeSuccess, 0x03, 'A', 'B', 'C'}; !bytes.Equal(in[:len(want)], want) {
+		t.Errorf("Control(GET_REPORT) data % x; want % x", in[:len(want)], want)
+	}
+}
+
+func TestHidrawFailedOpen(t *testing.T) {
+
+	root := t.TempDir()
+	tr := &HidrawTransport {
+		Path: filepath.Join(root, "hidraw0"),
+		SysfsDir: fakeSysfs(t, root, "hidraw0", "1-2", "0801")}
+
+	if _, err := NewHidrawDevice(tr); err == nil {
+		t.Fatal("NewHidrawDevice() succeeded with a missing hidraw node")
+	}
+
+	if tr.Feature != nil {
+		t.Errorf("Feature = %#v after failed open; want nil", tr.Feature)
+	}
+
+	if err := tr.Close(); err != nil {
+		t.Errorf("Close() after failed open = %v; want nil", err)
+	}
+}
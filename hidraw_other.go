@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package gomagtek
+
+import "fmt"
+
+// OpenHidrawDevices is only supported on Linux.
+func OpenHidrawDevices(sysfs, dev string, opts ...DeviceOption) (devices []*Device, errs []error) {
+	return devices, []error{fmt.Errorf("%s: hidraw not supported on this platform", getFunctionInfo())}
+}
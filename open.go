@@ -0,0 +1,88 @@
+package gomagtek
+
+import (
+	"github.com/google/gousb"
+	"fmt"
+)
+
+// OpenError describes a Magtek device that was seen on the bus but could not
+// be opened or initialized. It matches the category of the failure, such as
+// ErrAccess, with errors.Is.
+type OpenError struct {
+	BusNumber int
+	BusAddress int
+	PortPath string
+	ProductID string
+	Err error
+}
+
+func (e *OpenError) Error() string {
+	return fmt.Sprintf("device at bus %d address %d (port %s, product ID %s): %v",
+		e.BusNumber, e.BusAddress, e.PortPath, e.ProductID, e.Err)
+}
+
+func (e *OpenError) Unwrap() error {
+	return e.Err
+}
+
+// OpenDevices opens every Magtek device attached to the host and constructs
+// a Device for each with the options. Every device that was seen but could
+// not be opened or initialized is reported with an *OpenError, so that, for
+// example, a lack of permission is not mistaken for the absence of devices.
+// Errors from libusb that concern no particular Magtek device, such as the
+// 'not found' error returned on Windows systems, are ignored.
+func OpenDevices(uc *gousb.Context, opts ...DeviceOption) (devices []*Device, errs []error) {
+
+	var seen []*gousb.DeviceDesc
+
+	opened, err := uc.OpenDevices(func(desc *gousb.DeviceDesc) bool {
+		if uint16(desc.Vendor) == MagtekVendorID {
+			seen = append(seen, desc)
+			return true
+		}
+		return false
+	})
+
+	isOpen := make(map[[2]int]bool)
+
+	for _, gd := range opened {
+
+		isOpen[[2]int{gd.Desc.Bus, gd.Desc.Address}] = true
+		d, e := NewDevice(gd, opts...)
+
+		if e != nil {
+			gd.Close()
+			errs = append(errs, newOpenError(gd.Desc, e))
+			continue
+		}
+
+		devices = append(devices, d)
+	}
+
+	for _, desc := range seen {
+
+		if isOpen[[2]int{desc.Bus, desc.Address}] {
+			continue
+		}
+
+		e := err
+
+		if e == nil {
+			e = fmt.Errorf("%s: device could not be opened", getFunctionInfo())
+		}
+
+		errs = append(errs, newOpenError(desc, classify(e)))
+	}
+
+	return devices, errs
+}
+
+// newOpenError constructs an OpenError for the device.
+func newOpenError(desc *gousb.DeviceDesc, err error) (*OpenError) {
+	return &OpenError {
+		BusNumber: desc.Bus,
+		BusAddress: desc.Address,
+		PortPath: portPath(desc),
+		ProductID: desc.Product.String(),
+		Err: err}
+}
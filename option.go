@@ -0,0 +1,178 @@
+package gomagtek
+
+import "context"
+
+// Logger receives diagnostic messages from a Device, such as descriptor
+// errors that are otherwise ignored and retried commands. *log.Logger
+// satisfies it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// DeviceOption changes how NewDevice opens a device.
+type DeviceOption func(*deviceOptions)
+
+type deviceOptions struct {
+	bufferSize int
+	noProbe bool
+	lazy bool
+	strict bool
+	logger Logger
+}
+
+// WithBufferSize sets the vendor command buffer size of the device, so that
+// it does not have to be found by trial and error. A size of zero or less
+// leaves the buffer size to be probed.
+func WithBufferSize(size int) DeviceOption {
+	return func(o *deviceOptions) {o.bufferSize = size}
+}
+
+// WithoutProbe opens the device without sending any vendor commands. The
+// buffer size is taken from the product ID when it identifies the reader
+// family; otherwise it is probed when the first vendor command is sent.
+func WithoutProbe() DeviceOption {
+	return func(o *deviceOptions) {o.noProbe = true}
+}
+
+// WithLazyDescriptors defers reading the device and configuration
+// descriptors until a method needs them. Until then the DeviceDescriptor and
+// ConfigDescriptor fields are zero. Errors from the deferred read are handled
+// as at open: ignored, or returned by the method if WithStrict is also given.
+func WithLazyDescriptors() DeviceOption {
+	return func(o *deviceOptions) {o.lazy = true}
+}
+
+// WithStrict makes NewDevice fail if the descriptors cannot be read, rather
+// than ignoring the error and leaving them zero. With WithLazyDescriptors, it
+// applies when the descriptors are first needed instead: the method that
+// needs them fails, and they are read again on the next call.
+func WithStrict() DeviceOption {
+	return func(o *deviceOptions) {o.strict = true}
+}
+
+// WithLogger sets the Logger of the device.
+func WithLogger(l Logger) DeviceOption {
+	return func(o *deviceOptions) {o.logger = l}
+}
+
+// knownBufferSizes maps the product IDs that identify a reader family to the
+// buffer size of that family. Keyboard-emulation readers of both families
+// share a product ID and must be probed.
+var knownBufferSizes = map[uint16]int {
+	SureswipeHidPID: BufferSizeSureswipe,
+	MagnesafeSwipeHidPID: BufferSizeMagnesafe,
+	MagnesafeInsertHidPID: BufferSizeMagnesafe,
+	MagnesafeWirelessHidPID: BufferSizeMagnesafe}
+
+// open applies the options to a newly constructed Device, finding its buffer
+// size and reading its descriptors as they direct.
+func (d *Device) open(opts []DeviceOption) (err error) {
+
+	o := new(deviceOptions)
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	d.Logger = o.logger
+	d.strict = o.strict
+
+	switch {
+
+	case o.bufferSize > 0:
+		d.BufferSize = o.bufferSize
+
+	case o.noProbe:
+		d.BufferSize = knownBufferSizes[uint16(d.Desc.Product)]
+
+	default:
+		if err = d.findBufferSize(); err != nil {
+			return err
+		}
+		d.logf("buffer size %d", d.BufferSize)
+	}
+
+	if o.lazy {
+		return err
+	}
+
+	if err = d.loadDescriptors(); err != nil {
+		if o.strict {
+			return err
+		}
+		d.logf("%v", err)
+	}
+
+	d.descLoaded = true
+
+	return nil
+}
+
+// ensureBufferSize probes for the buffer size if it is not yet known.
+func (d *Device) ensureBufferSize() (err error) {
+
+	d.initMutex.Lock()
+	defer d.initMutex.Unlock()
+
+	if d.BufferSize == 0 {
+		err = d.findBufferSize()
+	}
+
+	return err
+}
+
+// ensureDescriptors reads the descriptors if they have not been read yet. In
+// strict mode an error is returned and the descriptors are tried again on the
+// next call; otherwise the error is logged and ignored, as at open.
+func (d *Device) ensureDescriptors(ctx context.Context) (err error) {
+
+	d.initMutex.Lock()
+	defer d.initMutex.Unlock()
+
+	if d.descLoaded {
+		return err
+	}
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	if err = d.loadDescriptors(); err != nil {
+		if d.strict {
+			return err
+		}
+		d.logf("%v", err)
+	}
+
+	d.descLoaded = true
+
+	return nil
+}
+
+// loadDescriptors reads the device and configuration descriptors, returning
+// the first error.
+func (d *Device) loadDescriptors() (err error) {
+
+	err = d.getDeviceDescriptor()
+
+	if e := d.getConfigDescriptor(); err == nil {
+		err = e
+	}
+
+	return err
+}
+
+// logf sends a message to the Logger, if there is one, prefixed with the bus
+// number and address of the device when they are known.
+func (d *Device) logf(format string, v ...interface{}) {
+
+	if d.Logger == nil {
+		return
+	}
+
+	if d.Device != nil && d.Desc != nil {
+		format = d.GetBusNumber() + ":" + d.GetBusAddress() + ": " + format
+	}
+
+	d.Logger.Printf(format, v...)
+}
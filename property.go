@@ -0,0 +1,104 @@
+package gomagtek
+
+import (
+	"encoding/hex"
+	"strconv"
+)
+
+// Property describes a device NVRAM property documented in the MagneSafe V5
+// Communication Reference Manual and the Sure Swipe USB HID Technical
+// Reference Manual. Not every reader supports every property; unsupported
+// properties fail with a command error.
+type Property struct {
+	ID uint8
+	Name string
+	Type string
+	Writable bool
+}
+
+// Properties lists the documented properties that apply to USB readers. The
+// factory serial number is marked read-only because it can only be written
+// once, and the interface type is listed last because changing it alters which
+// other properties are available.
+var Properties = []Property {
+	{PropSoftwareID, "software_id", "string", false},
+	{PropDeviceSN, "device_sn", "string", true},
+	{PropPollingInterval, "polling_interval", "byte", true},
+	{PropFactorySN, "factory_sn", "string", false},
+	{PropProductVer, "product_ver", "string", false},
+	{PropTrackIDEnable, "track_id_enable", "byte", true},
+	{PropISOTrackMask, "iso_track_mask", "string", true},
+	{PropAAMVATrackMask, "aamva_track_mask", "string", true},
+	{PropMaxPacketSize, "max_packet_size", "byte", true},
+	{PropDataSendFlags, "data_send_flags", "byte", true},
+	{PropMPFlags, "mp_flags", "byte", true},
+	{PropActiveKeymap, "active_keymap", "byte", true},
+	{PropKeypressConversion, "keypress_conversion", "byte", true},
+	{PropCRCFlag, "crc_flag", "byte", true},
+	{PropKbSureSwipeFlag, "kb_sureswipe_flag", "byte", true},
+	{PropDecodeEnable, "decode_enable", "byte", true},
+	{PropSSJISType2, "ss_jis_type2", "byte", true},
+	{PropESJISType2, "es_jis_type2", "byte", true},
+	{PropPreCardString, "pre_card_string", "string", true},
+	{PropPostCardString, "post_card_string", "string", true},
+	{PropPreTrackString, "pre_track_string", "string", true},
+	{PropPostTrackString, "post_track_string", "string", true},
+	{PropTerminationString, "termination_string", "string", true},
+	{PropFS, "fs", "byte", true},
+	{PropSSTrack1ISO, "ss_track1_iso", "byte", true},
+	{PropSSTrack2ISO, "ss_track2_iso", "byte", true},
+	{PropSSTrack3ISO, "ss_track3_iso", "byte", true},
+	{PropSSTrack3AAMVA, "ss_track3_aamva", "byte", true},
+	{PropSSTrack2Bit7, "ss_track2_7bit", "byte", true},
+	{PropSSTrack3Bit7, "ss_track3_7bit", "byte", true},
+	{PropES, "es", "byte", true},
+	{PropFormatCode, "format_code", "string", true},
+	{PropESTrack1, "es_track1", "byte", true},
+	{PropESTrack2, "es_track2", "byte", true},
+	{PropESTrack3, "es_track3", "byte", true},
+	{PropSendEncCounter, "send_enc_counter", "byte", true},
+	{PropMaskOtherCards, "mask_other_cards", "byte", true},
+	{PropMSRDirection, "msr_direction", "byte", true},
+	{PropCardInserted, "card_inserted", "byte", false},
+	{PropSendClearAAMVA, "send_clear_aamva", "byte", true},
+	{PropHidSureSwipeFlag, "hid_sureswipe_flag", "byte", true},
+	{PropHostPollTimeout, "host_poll_timeout", "byte", true},
+	{PropInterfaceType, "interface_type", "byte", true}}
+
+// LookupProperty returns the documented property with the given ID or name.
+func LookupProperty(key string) (p Property, ok bool) {
+
+	id, err := strconv.ParseUint(key, 0, 8)
+
+	for _, p = range Properties {
+		if p.Name == key || (err == nil && p.ID == uint8(id)) {
+			return p, true
+		}
+	}
+
+	return Property{}, false
+}
+
+// Format renders a raw property value for display: strings as text and all
+// other types as hexadecimal.
+func (p Property) Format(value string) string {
+
+	if p.Type == "string" {
+		return strconv.Quote(value)
+	}
+
+	return "0x" + hex.EncodeToString([]byte(value))
+}
+
+// Parse converts a value from its text representation, as used in a desired
+// state, into the raw form stored in device NVRAM.
+func (p Property) Parse(value string) (raw string, err error) {
+
+	if p.Type == "string" {
+		return value, err
+	}
+
+	n, err := strconv.ParseUint(value, 0, 8)
+
+	return string([]byte{uint8(n)}), err
+}
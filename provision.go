@@ -0,0 +1,83 @@
+package gomagtek
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"bytes"
+	"fmt"
+)
+
+// Provisioner requests device serial numbers from a provisioning server. The
+// request carries the inventory information of the device in JSON format and
+// is signed when Key is set. The server responds with the serial number in
+// plain text.
+type Provisioner struct {
+	URL string
+	Token string
+	Key []byte
+	Client *http.Client
+}
+
+// NewProvisioner constructs a new Provisioner.
+func NewProvisioner(url string) (*Provisioner) {
+	return &Provisioner{URL: url, Client: &http.Client{Timeout: DefaultUploadTimeout}}
+}
+
+// RequestDeviceSN obtains a serial number for the device from the server.
+func (p *Provisioner) RequestDeviceSN(i *DeviceInfo) (sn string, err error) {
+
+	body, err := i.JSON(false)
+
+	if err != nil {
+		return sn, fmt.Errorf("%s: %v", getFunctionInfo(), err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, p.URL, bytes.NewReader(body))
+
+	if err != nil {
+		return sn, fmt.Errorf("%s: %v", getFunctionInfo(), err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	if len(p.Token) > 0 {
+		req.Header.Set("Authorization", "Bearer " + p.Token)
+	}
+
+	if len(p.Key) > 0 {
+		if err = SignRequest(req, p.Key, body); err != nil {
+			return sn, err
+		}
+	}
+
+	client := p.Client
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+
+	if err != nil {
+		return sn, fmt.Errorf("%s: %v", getFunctionInfo(), err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return sn, fmt.Errorf("%s: request rejected: %s", getFunctionInfo(), resp.Status)
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+
+	if err != nil {
+		return sn, fmt.Errorf("%s: %v", getFunctionInfo(), err)
+	}
+
+	if sn = strings.TrimSpace(string(b)); len(sn) == 0 {
+		err = fmt.Errorf("%s: empty serial number", getFunctionInfo())
+	}
+
+	return sn, err
+}
@@ -2,6 +2,7 @@ package gomagtek
 
 import (
 	"strings"
+	"context"
 	"fmt"
 	"os"
 )
@@ -30,7 +31,8 @@ var FieldFlags = []string {
 	"bs",
 	"sn",
 	"fsn",
-	"dsn"}
+	"dsn",
+	"sst"}
 
 var FlagFieldMap = map[string]string {
 	"hn":	"hostname",
@@ -43,7 +45,8 @@ var FlagFieldMap = map[string]string {
 	"bs":	"buffer_size",
 	"sn":	"device_sn",
 	"fsn":	"factory_sn",
-	"dsn":	"descript_sn"}
+	"dsn":	"descript_sn",
+	"sst":	"serial_status"}
 
 var FieldTitleMap = map[string]string {
 	"hostname":	"Host Name",
@@ -56,7 +59,8 @@ var FieldTitleMap = map[string]string {
 	"buffer_size":	"Buffer Size",
 	"device_sn":	"Device Serial Number",
 	"factory_sn":	"Factory Serial Number",
-	"descript_sn":	"Descriptor Serial Number"}
+	"descript_sn":	"Descriptor Serial Number",
+	"serial_status":	"Serial Number Status"}
 
 var FormatTypes = []string {
 	"csv",
@@ -68,7 +72,12 @@ var FormatTitle = map[string]string {
 
 // Report receives a slice of properties desired in the report and returns a
 // populated report.
-func (d *Device) Report(fields []string) (r Report, err error) {
+func (d *Device) Report(fields []string) (Report, error) {
+	return d.ReportContext(context.Background(), fields)
+}
+
+// ReportContext is Report with a context.
+func (d *Device) ReportContext(ctx context.Context, fields []string) (r Report, err error) {
 
 	for _, f := range fields {
 
@@ -82,23 +91,27 @@ func (d *Device) Report(fields []string) (r Report, err error) {
 		case "vid", FlagFieldMap["vid"]:
 			rf.Value, rf.Error = d.GetVendorID(), nil
 		case "vn", FlagFieldMap["vn"]:
-			rf.Value, rf.Error = d.GetVendorName()
+			rf.Value, rf.Error = d.GetVendorNameContext(ctx)
 		case "pid", FlagFieldMap["pid"]:
 			rf.Value, rf.Error = d.GetProductID(), nil
 		case "pn", FlagFieldMap["pn"]:
-			rf.Value, rf.Error = d.GetProductName()
+			rf.Value, rf.Error = d.GetProductNameContext(ctx)
 		case "pv", FlagFieldMap["pv"]:
-			rf.Value, rf.Error = d.GetProductVer()
+			rf.Value, rf.Error = d.GetProductVerContext(ctx)
 		case "sid", FlagFieldMap["sid"]:
-			rf.Value, rf.Error = d.GetSoftwareID()
+			rf.Value, rf.Error = d.GetSoftwareIDContext(ctx)
 		case "bs", FlagFieldMap["bs"]:
 			rf.Value, rf.Error = d.GetBufferSize()
 		case "sn", FlagFieldMap["sn"]:
-			rf.Value, rf.Error = d.GetDeviceSN()
+			rf.Value, rf.Error = d.GetDeviceSNContext(ctx)
 		case "fsn", FlagFieldMap["fsn"]:
-			rf.Value, rf.Error = d.GetFactorySN()
+			rf.Value, rf.Error = d.GetFactorySNContext(ctx)
 		case "dsn", FlagFieldMap["dsn"]:
-			rf.Value, rf.Error = d.GetDescriptSN()
+			rf.Value, rf.Error = d.GetDescriptSNContext(ctx)
+		case "sst", FlagFieldMap["sst"]:
+			var ss *SerialStatus
+			ss, rf.Error = d.SerialStatusContext(ctx)
+			rf.Value = ss.String()
 		default:
 			if err == nil {
 				err = fmt.Errorf("%s: unsupported field(s):", getFunctionInfo())
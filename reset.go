@@ -0,0 +1,191 @@
+package gomagtek
+
+import (
+	"github.com/google/gousb"
+	"context"
+	"errors"
+	"time"
+	"fmt"
+)
+
+// DefaultReopenTimeout bounds the wait for a reset device to reappear when the
+// context has no deadline.
+const DefaultReopenTimeout time.Duration = 15 * time.Second
+
+const reopenInterval time.Duration = 250 * time.Millisecond
+
+// UsbResetReopen performs a USB port reset, waits for the device to
+// re-enumerate, and returns a newly opened Device for it. If the descriptors
+// of the device changed, for example because its serial number was changed,
+// the reset makes the kernel re-enumerate it and libusb reports it as not
+// found; the device is then waited for as after a device reset. See
+// DeviceResetReopen.
+func (d *Device) UsbResetReopen(ctx context.Context, uc *gousb.Context) (*Device, error) {
+	return d.resetReopen(ctx, uc, true)
+}
+
+// DeviceResetReopen resets the device using low-level vendor commands, waits
+// for the same physical device to reappear, and returns a newly opened Device
+// for it with refreshed descriptors. The device is matched by port path and,
+// if it has one, factory serial number. The wait ends at the context
+// deadline, or after DefaultReopenTimeout if there is none. Settings such as
+// the policies, audit sink, and timeouts are carried over to the new Device.
+// If the old Device had claimed the interface, its claim is released and the
+// interface is claimed by the new Device instead. The old Device is stale
+// after the reset; the caller remains responsible for closing it.
+func (d *Device) DeviceResetReopen(ctx context.Context, uc *gousb.Context) (*Device, error) {
+	return d.resetReopen(ctx, uc, false)
+}
+
+// resetReopen performs a USB port reset if usb is true or a device reset
+// otherwise, then waits for the device to reappear.
+func (d *Device) resetReopen(ctx context.Context, uc *gousb.Context, usb bool) (nd *Device, err error) {
+
+	if d.Transport != nil {
+		return nil, fmt.Errorf("%s: reopen not supported by transport", getFunctionInfo())
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultReopenTimeout)
+		defer cancel()
+	}
+
+	port, addr := d.GetPortPath(), d.Desc.Address
+	fsn, err := d.GetFactorySNContext(ctx)
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", getFunctionInfo(), err)
+	}
+
+	mustLeave := !usb
+
+	if usb {
+		err = d.UsbResetContext(ctx)
+	} else {
+		err = d.deviceReset(ctx)
+	}
+
+	// A device whose descriptors changed is re-enumerated during the USB
+	// reset and is no longer found at its old address.
+
+	if usb && errors.Is(err, ErrNotFound) {
+		d.logf("re-enumerating after USB reset: %v", err)
+		err, mustLeave = nil, true
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", getFunctionInfo(), err)
+	}
+
+	// The old handle keeps its claim on the interface across the reset,
+	// so it must let go before the new handle can claim it.
+
+	claimed := d.Claimed()
+
+	if claimed {
+		if err = d.Release(); err != nil {
+			d.logf("%v", err)
+		}
+	}
+
+	if nd, err = d.reopen(ctx, uc, port, fsn, addr, mustLeave, claimed); err != nil {
+		err = fmt.Errorf("%s: %w", getFunctionInfo(), err)
+	}
+
+	return nd, err
+}
+
+// reopen polls for a Magtek device at the port path with the factory serial
+// number and opens it. A USB port reset re-enumerates the device before it
+// returns, but a device reset only starts the process; if mustLeave is set,
+// the device still present at the old bus address is not accepted until it
+// has dropped off the bus or reappeared at a new address. If claim is set,
+// the interface of the new Device is claimed.
+func (d *Device) reopen(ctx context.Context, uc *gousb.Context, port, fsn string, addr int, mustLeave, claim bool) (nd *Device, err error) {
+
+	gone := !mustLeave
+
+	for {
+
+		var seen bool
+
+		devices, _ := uc.OpenDevices(func(desc *gousb.DeviceDesc) bool {
+
+			if uint16(desc.Vendor) != MagtekVendorID || portPath(desc) != port {
+				return false
+			}
+
+			seen = true
+
+			return gone || desc.Address != addr
+		})
+
+		if !seen {
+			gone = true
+		}
+
+		for _, gd := range devices {
+			if nd != nil {
+				gd.Close()
+			} else if nd, err = d.adopt(ctx, gd, fsn, claim); nd == nil {
+				gd.Close()
+			}
+		}
+
+		if nd != nil {
+			return nd, nil
+		}
+
+		select {
+
+		case <-ctx.Done():
+			if err == nil {
+				err = ctx.Err()
+			}
+			return nil, fmt.Errorf("device at port %s did not reappear: %w", port, err)
+
+		case <-time.After(reopenInterval):
+		}
+	}
+}
+
+// adopt constructs a Device for a newly opened gousb device if it has the
+// factory serial number, carrying over the settings of the old Device and
+// claiming the interface if claim is set. It returns nil if the device is not
+// ready or is not the one expected.
+func (d *Device) adopt(ctx context.Context, gd *gousb.Device, fsn string, claim bool) (nd *Device, err error) {
+
+	if nd, err = NewDevice(gd, WithBufferSize(d.BufferSize), WithLogger(d.Logger)); err != nil {
+		return nil, err
+	}
+
+	nd.SerialPolicy = d.SerialPolicy
+	nd.OperationPolicy = d.OperationPolicy
+	nd.Audit = d.Audit
+	nd.Timeout = d.Timeout
+	nd.ResetDelay = d.ResetDelay
+	nd.RetryPolicy = d.RetryPolicy
+	nd.AutoDetach = d.AutoDetach
+
+	if len(fsn) > 0 {
+
+		got, err := nd.GetFactorySNContext(ctx)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if got != fsn {
+			return nil, fmt.Errorf("%s: factory serial number %q at port, want %q", getFunctionInfo(), got, fsn)
+		}
+	}
+
+	if claim {
+		if err = nd.Claim(); err != nil {
+			return nil, err
+		}
+	}
+
+	return nd, nil
+}
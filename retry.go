@@ -0,0 +1,172 @@
+package gomagtek
+
+import (
+	"github.com/google/gousb"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultRetryAttempts int = 3
+	DefaultRetryBackoff time.Duration = 50 * time.Millisecond
+	DefaultRetryMaxBackoff time.Duration = time.Second
+)
+
+// RetryPolicy governs the retrying of vendor commands that fail with
+// transient errors, such as the pipe and timeout errors seen on busy hubs.
+// Attempts is the total number of attempts, including the first; a value of
+// one or less disables retries. Backoff is the delay before the first retry,
+// doubled for each further retry up to MaxBackoff. Retriable decides which
+// errors are worth retrying; if it is nil, IsRetriable is used.
+//
+// A stall on the control endpoint is cleared by the next setup packet, so
+// every retry resends the whole command and reads the response again. Reset
+// commands are never retried.
+type RetryPolicy struct {
+	Attempts int
+	Backoff time.Duration
+	MaxBackoff time.Duration
+	Retriable func(error) bool
+}
+
+// NewRetryPolicy constructs a RetryPolicy with the default settings.
+func NewRetryPolicy() (*RetryPolicy) {
+	return &RetryPolicy {
+		Attempts: DefaultRetryAttempts,
+		Backoff: DefaultRetryBackoff,
+		MaxBackoff: DefaultRetryMaxBackoff}
+}
+
+// IsRetriable reports whether a transfer error is transient: a pipe error or
+// stall, a timeout, an I/O error, a busy resource, or an interrupted call.
+// Errors such as access denied or no device are fatal.
+func IsRetriable(err error) bool {
+
+	for _, target := range []error{ErrPipe, ErrTimeout, ErrBusy,
+		gousb.ErrorIO, gousb.ErrorInterrupted, gousb.TransferError} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// retriable reports whether the policy allows retrying the error.
+func (rp *RetryPolicy) retriable(err error) bool {
+
+	if rp.Retriable != nil {
+		return rp.Retriable(err)
+	}
+
+	return IsRetriable(err)
+}
+
+// backoff returns the delay before the given retry, counting from one.
+func (rp *RetryPolicy) backoff(retry int) (delay time.Duration) {
+
+	delay = rp.Backoff
+
+	for i := 1; i < retry && (rp.MaxBackoff <= 0 || delay < rp.MaxBackoff); i++ {
+		delay *= 2
+	}
+
+	if rp.MaxBackoff > 0 && delay > rp.MaxBackoff {
+		delay = rp.MaxBackoff
+	}
+
+	return delay
+}
+
+// CommandStats counts the vendor commands sent to a device and the errors
+// encountered, to help pick out flaky readers. Commands counts each command
+// once however often it was attempted; Retries counts the extra attempts, and
+// Failures the commands that failed in the end. PipeErrors and Timeouts count
+// every attempt that failed with a pipe error or stall, or with a timeout.
+type CommandStats struct {
+	Commands uint64
+	Retries uint64
+	Failures uint64
+	PipeErrors uint64
+	Timeouts uint64
+}
+
+// commandStats holds the counters of a device.
+type commandStats struct {
+	sync.Mutex
+	CommandStats
+}
+
+// Stats returns a snapshot of the command counters of the device.
+func (d *Device) Stats() (CommandStats) {
+
+	d.stats.Lock()
+	defer d.stats.Unlock()
+
+	return d.stats.CommandStats
+}
+
+// exchangeRetry performs a command exchange, retrying transient failures
+// according to the retry policy, and updates the command counters. A nil
+// policy allows a single attempt. The transfer error of the last attempt is
+// returned unwrapped.
+func (d *Device) exchangeRetry(ctx context.Context, rp *RetryPolicy, out, in []byte) (n int, err error) {
+
+	attempts := 1
+
+	if rp != nil && rp.Attempts > 1 {
+		attempts = rp.Attempts
+	}
+
+	for i := 0; ; i++ {
+
+		n, err = d.exchange(ctx, out, in)
+		d.count(i, err)
+
+		if err == nil || i + 1 >= attempts || !rp.retriable(err) {
+			break
+		}
+
+		d.logf("retrying command after attempt %d: %v", i + 1, err)
+
+		select {
+
+		case <-ctx.Done():
+			return n, ctx.Err()
+
+		case <-time.After(rp.backoff(i + 1)):
+		}
+	}
+
+	if err != nil {
+		d.stats.Lock()
+		d.stats.Failures++
+		d.stats.Unlock()
+	}
+
+	return n, err
+}
+
+// count updates the command counters after an attempt.
+func (d *Device) count(attempt int, err error) {
+
+	d.stats.Lock()
+	defer d.stats.Unlock()
+
+	if attempt == 0 {
+		d.stats.Commands++
+	} else {
+		d.stats.Retries++
+	}
+
+	switch {
+
+	case errors.Is(err, ErrPipe):
+		d.stats.PipeErrors++
+
+	case errors.Is(err, ErrTimeout):
+		d.stats.Timeouts++
+	}
+}
@@ -0,0 +1,79 @@
+package gomagtek
+
+import (
+	"strings"
+	"fmt"
+)
+
+// Selector picks particular devices out of the devices attached to a host.
+// Empty fields match any device. DeviceSN and FactorySN are compared with
+// the values in device NVRAM, BusAddr with "bus:address", PortPath with the
+// value returned by GetPortPath, and ProductID with the hexadecimal product
+// ID. If Index is zero or greater, only the device at that position among
+// the otherwise matching devices is selected.
+type Selector struct {
+	DeviceSN string
+	FactorySN string
+	BusAddr string
+	PortPath string
+	ProductID string
+	Index int
+}
+
+// NewSelector constructs a Selector that matches every device.
+func NewSelector() (*Selector) {
+	return &Selector{Index: -1}
+}
+
+// Match reports whether the device satisfies every selection criterion other
+// than Index.
+func (s *Selector) Match(d *Device) bool {
+
+	if len(s.BusAddr) > 0 && s.BusAddr != d.GetBusNumber() + ":" + d.GetBusAddress() {
+		return false
+	}
+
+	if len(s.PortPath) > 0 && s.PortPath != d.GetPortPath() {
+		return false
+	}
+
+	if len(s.ProductID) > 0 && !strings.EqualFold(
+		strings.TrimPrefix(s.ProductID, "0x"), d.GetProductID()) {
+		return false
+	}
+
+	if len(s.FactorySN) > 0 {
+		if fsn, err := d.GetFactorySN(); err != nil || fsn != s.FactorySN {
+			return false
+		}
+	}
+
+	if len(s.DeviceSN) > 0 {
+		if sn, err := d.GetDeviceSN(); err != nil || sn != s.DeviceSN {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Select returns the devices chosen by the selector, preserving their order.
+func (s *Selector) Select(devices []*Device) (selected []*Device, err error) {
+
+	for _, d := range devices {
+		if s.Match(d) {
+			selected = append(selected, d)
+		}
+	}
+
+	if s.Index < 0 {
+		return selected, err
+	}
+
+	if s.Index >= len(selected) {
+		return nil, fmt.Errorf("%s: index %d out of range: %d candidate devices",
+			getFunctionInfo(), s.Index, len(selected))
+	}
+
+	return selected[s.Index:s.Index+1], err
+}
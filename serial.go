@@ -0,0 +1,121 @@
+package gomagtek
+
+import (
+	"strings"
+	"regexp"
+	"fmt"
+)
+
+const (
+	CharsetNumeric string = "0123456789"
+	CharsetAlphanumeric string = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+)
+
+// SerialPolicy validates a device serial number before it is written to
+// device NVRAM.
+type SerialPolicy interface {
+	Validate(sn string) error
+}
+
+// CheckDigitFunc computes the check character for a serial number payload.
+type CheckDigitFunc func(payload string) (byte, error)
+
+// SerialRules is a SerialPolicy built from common serial number constraints.
+// Zero-valued fields impose no constraint. When CheckDigit is set, the last
+// character of the serial number must match the check character computed
+// over the preceding characters.
+type SerialRules struct {
+	MinLength int
+	MaxLength int
+	Charset string
+	Prefix string
+	Pattern *regexp.Regexp
+	CheckDigit CheckDigitFunc
+}
+
+// Validate checks the serial number against each of the rules.
+func (sr *SerialRules) Validate(sn string) (err error) {
+
+	if sr.MinLength > 0 && len(sn) < sr.MinLength {
+		return fmt.Errorf("%s: serial number %q shorter than %d characters",
+			getFunctionInfo(), sn, sr.MinLength)
+	}
+
+	if sr.MaxLength > 0 && len(sn) > sr.MaxLength {
+		return fmt.Errorf("%s: serial number %q longer than %d characters",
+			getFunctionInfo(), sn, sr.MaxLength)
+	}
+
+	if len(sr.Charset) > 0 {
+		if i := strings.IndexFunc(sn, func(r rune) bool {
+			return !strings.ContainsRune(sr.Charset, r)
+		}); i >= 0 {
+			return fmt.Errorf("%s: serial number %q contains invalid character %q",
+				getFunctionInfo(), sn, sn[i])
+		}
+	}
+
+	if !strings.HasPrefix(sn, sr.Prefix) {
+		return fmt.Errorf("%s: serial number %q lacks prefix %q",
+			getFunctionInfo(), sn, sr.Prefix)
+	}
+
+	if sr.Pattern != nil && !sr.Pattern.MatchString(sn) {
+		return fmt.Errorf("%s: serial number %q does not match pattern %q",
+			getFunctionInfo(), sn, sr.Pattern.String())
+	}
+
+	if sr.CheckDigit != nil {
+
+		if len(sn) < 2 {
+			return fmt.Errorf("%s: serial number %q too short for check digit",
+				getFunctionInfo(), sn)
+		}
+
+		cd, err := sr.CheckDigit(sn[:len(sn)-1])
+
+		if err != nil {
+			return fmt.Errorf("%s: %v", getFunctionInfo(), err)
+		}
+
+		if sn[len(sn)-1] != cd {
+			return fmt.Errorf("%s: serial number %q has invalid check digit",
+				getFunctionInfo(), sn)
+		}
+	}
+
+	return err
+}
+
+// LuhnMod10 computes a Luhn check digit over a numeric payload.
+func LuhnMod10(payload string) (byte, error) {
+	return luhnModN(payload, CharsetNumeric)
+}
+
+// LuhnMod36 computes a Luhn mod N check character over an alphanumeric
+// payload. Lowercase letters are treated as uppercase.
+func LuhnMod36(payload string) (byte, error) {
+	return luhnModN(strings.ToUpper(payload), CharsetAlphanumeric)
+}
+
+// luhnModN implements the Luhn mod N algorithm over the given alphabet.
+func luhnModN(payload, alphabet string) (byte, error) {
+
+	n := len(alphabet)
+	factor, sum := 2, 0
+
+	for i := len(payload) - 1; i >= 0; i-- {
+
+		cp := strings.IndexByte(alphabet, payload[i])
+
+		if cp < 0 {
+			return 0, fmt.Errorf("invalid check digit input character %q", payload[i])
+		}
+
+		addend := factor * cp
+		sum += addend / n + addend % n
+		factor = 3 - factor
+	}
+
+	return alphabet[(n - sum % n) % n], nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"github.com/jscherff/gomagtek"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"fmt"
+	"net"
+)
+
+// inventoryHandler accepts DeviceInfo uploads in JSON or XML format and
+// records them along with the time of receipt and the source host.
+func inventoryHandler(w http.ResponseWriter, r *http.Request) {
+
+	di, source, ok := receive(w, r)
+
+	if !ok {
+		return
+	}
+
+	rec := &Record{Received: time.Now(), Source: source, Info: di}
+
+	// Trust the observation time only if it is not in the future.
+
+	if t, err := time.Parse(time.RFC3339Nano, di.Observed); err == nil && !t.After(rec.Received) {
+		rec.Observed = t
+	}
+
+	if err := store.Add(rec); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+// issueHandler accepts a DeviceInfo payload and responds with the serial
+// number issued to the reader in plain text.
+func issueHandler(w http.ResponseWriter, r *http.Request) {
+
+	di, source, ok := receive(w, r)
+
+	if !ok {
+		return
+	}
+
+	sn, err := issuer.Issue(di.FactorySN, source)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintln(w, sn)
+}
+
+// receive reads, authenticates, and decodes a DeviceInfo payload. It writes
+// an error response and returns false if the request is not acceptable.
+func receive(w http.ResponseWriter, r *http.Request) (di *gomagtek.DeviceInfo, source string, ok bool) {
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return di, source, false
+	}
+
+	if !authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return di, source, false
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return di, source, false
+	}
+
+	if verifier != nil {
+		if err = verifier.Verify(r, body); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return di, source, false
+		}
+	}
+
+	if strings.Contains(r.Header.Get("Content-Type"), "xml") {
+		di, err = gomagtek.NewDeviceInfoFromXML(body)
+	} else {
+		di, err = gomagtek.NewDeviceInfoFromJSON(body)
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return di, source, false
+	}
+
+	if len(di.FactorySN) == 0 {
+		http.Error(w, "factory serial number missing", http.StatusBadRequest)
+		return di, source, false
+	}
+
+	if source, _, err = net.SplitHostPort(r.RemoteAddr); err != nil {
+		source = r.RemoteAddr
+	}
+
+	return di, source, true
+}
+
+// hostHandler returns the latest record of every reader attached to the
+// workstation named in the 'name' parameter.
+func hostHandler(w http.ResponseWriter, r *http.Request) {
+
+	name := r.URL.Query().Get("name")
+
+	respond(w, r, store.Latest(func(rec *Record) bool {
+		return strings.EqualFold(rec.Info.HostName, name)
+	}))
+}
+
+// serialHandler returns the full history of the readers whose factory serial
+// number or current device serial number matches the 'sn' parameter.
+func serialHandler(w http.ResponseWriter, r *http.Request) {
+
+	sn := r.URL.Query().Get("sn")
+	rs := store.History(sn)
+
+	if len(rs) == 0 && len(sn) > 0 {
+
+		matches := store.Latest(func(rec *Record) bool {
+			return rec.Info.DeviceSN == sn
+		})
+
+		for _, rec := range matches {
+			rs = append(rs, store.History(rec.Info.FactorySN)...)
+		}
+	}
+
+	respond(w, r, rs)
+}
+
+// staleHandler returns the latest record of every reader that has not been
+// seen in the number of days given in the 'days' parameter.
+func staleHandler(w http.ResponseWriter, r *http.Request) {
+
+	days, err := strconv.Atoi(r.URL.Query().Get("days"))
+
+	if err != nil || days < 0 {
+		http.Error(w, "invalid days parameter", http.StatusBadRequest)
+		return
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -days)
+
+	respond(w, r, store.Latest(func(rec *Record) bool {
+		return rec.Seen().Before(cutoff)
+	}))
+}
+
+// nosnHandler returns the latest record of every reader whose configurable
+// device serial number is empty.
+func nosnHandler(w http.ResponseWriter, r *http.Request) {
+
+	respond(w, r, store.Latest(func(rec *Record) bool {
+		return len(rec.Info.DeviceSN) == 0
+	}))
+}
+
+// authorized checks the bearer token of the request when one is required.
+func authorized(r *http.Request) bool {
+	return len(*fToken) == 0 || r.Header.Get("Authorization") == "Bearer " + *fToken
+}
+
+// respond writes query results to the client in JSON format.
+func respond(w http.ResponseWriter, r *http.Request, rs []*Record) {
+
+	if !authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if rs == nil {
+		rs = []*Record{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rs)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"bufio"
+	"sync"
+	"time"
+	"fmt"
+	"os"
+)
+
+// Issue represents a serial number issued to a reader.
+type Issue struct {
+	Issued time.Time
+	Source string
+	FactorySN string
+	DeviceSN string
+}
+
+// Issuer hands out sequential device serial numbers built from a prefix and a
+// zero-padded counter. A reader that asks again receives the serial number it
+// was issued before. Issues are appended to a file, when one is given, so the
+// counter survives restarts.
+type Issuer struct {
+	Prefix string
+	Width int
+	mutex sync.Mutex
+	issued map[string]*Issue
+	file *os.File
+}
+
+// NewIssuer constructs a new Issuer, loading previous issues from the given
+// file. An empty filename yields an issuer that is held only in memory.
+func NewIssuer(prefix string, width int, fn string) (is *Issuer, err error) {
+
+	is = &Issuer{Prefix: prefix, Width: width, issued: make(map[string]*Issue)}
+
+	if len(fn) == 0 {
+		return is, err
+	}
+
+	if is.file, err = os.OpenFile(fn, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0640); err != nil {
+		return is, err
+	}
+
+	scanner := bufio.NewScanner(is.file)
+
+	for scanner.Scan() {
+
+		i := new(Issue)
+
+		if err = json.Unmarshal(scanner.Bytes(), i); err != nil {
+			return is, err
+		}
+
+		is.issued[i.FactorySN] = i
+	}
+
+	return is, scanner.Err()
+}
+
+// Close closes the backing file, if any.
+func (is *Issuer) Close() (err error) {
+	if is.file != nil {
+		err = is.file.Close()
+	}
+	return err
+}
+
+// Issue returns the serial number for the reader with the given factory
+// serial number, issuing a new one if necessary.
+func (is *Issuer) Issue(fsn, source string) (sn string, err error) {
+
+	is.mutex.Lock()
+	defer is.mutex.Unlock()
+
+	if i, ok := is.issued[fsn]; ok {
+		return i.DeviceSN, err
+	}
+
+	i := &Issue {
+		Issued: time.Now(),
+		Source: source,
+		FactorySN: fsn,
+		DeviceSN: fmt.Sprintf("%s%0*d", is.Prefix, is.Width, len(is.issued) + 1)}
+
+	if is.file != nil {
+
+		b, err := json.Marshal(i)
+
+		if err != nil {
+			return sn, err
+		}
+
+		if _, err = is.file.Write(append(b, '\n')); err != nil {
+			return sn, err
+		}
+	}
+
+	is.issued[fsn] = i
+
+	return i.DeviceSN, err
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"github.com/jscherff/gomagtek"
+	"net/http"
+	"flag"
+	"log"
+)
+
+var (
+	fAddr = flag.String("addr", ":8080", "Listen on `<address>`")
+	fFile = flag.String("file", "", "Persist inventory history to `<file>`")
+	fToken = flag.String("token", "", "Require bearer `<token>` from clients")
+	fKey = flag.String("key", "", "Require requests signed with HMAC `<key>`")
+	fPrefix = flag.String("prefix", "", "Issue serial numbers beginning with `<prefix>`")
+	fWidth = flag.Int("width", 5, "Pad issued serial number counter to `<n>` digits")
+	fIssued = flag.String("issued", "", "Persist issued serial numbers to `<file>`")
+)
+
+var (
+	store *Store
+	issuer *Issuer
+	verifier *gomagtek.Verifier
+)
+
+func main() {
+
+	flag.Parse()
+
+	var err error
+
+	if store, err = NewStore(*fFile); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	defer store.Close()
+
+	if issuer, err = NewIssuer(*fPrefix, *fWidth, *fIssued); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	defer issuer.Close()
+
+	if len(*fKey) > 0 {
+		verifier = gomagtek.NewVerifier([]byte(*fKey), 0)
+	}
+
+	http.HandleFunc("/inventory", inventoryHandler)
+	http.HandleFunc("/serial", issueHandler)
+	http.HandleFunc("/query/host", hostHandler)
+	http.HandleFunc("/query/serial", serialHandler)
+	http.HandleFunc("/query/stale", staleHandler)
+	http.HandleFunc("/query/nosn", nosnHandler)
+
+	log.Fatal(http.ListenAndServe(*fAddr, nil))
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"github.com/jscherff/gomagtek"
+	"encoding/json"
+	"bufio"
+	"sync"
+	"time"
+	"os"
+)
+
+// Record represents one inventory upload received from a workstation.
+// Observed is when the workstation saw the reader, which is earlier than
+// Received for uploads delivered from a spool.
+type Record struct {
+	Received time.Time
+	Observed time.Time
+	Source string
+	Info *gomagtek.DeviceInfo
+}
+
+// Seen returns when the reader was observed, or when the record was received
+// for records that do not say.
+func (r *Record) Seen() time.Time {
+
+	if r.Observed.IsZero() {
+		return r.Received
+	}
+
+	return r.Observed
+}
+
+// Store holds the upload history of every reader, keyed by factory serial
+// number. When backed by a file, every record is appended to it as a line of
+// JSON and the history is reloaded from it at startup.
+type Store struct {
+	mutex sync.RWMutex
+	history map[string][]*Record
+	file *os.File
+}
+
+// NewStore constructs a new Store, loading any history from the given file.
+// An empty filename yields a store that is held only in memory.
+func NewStore(fn string) (s *Store, err error) {
+
+	s = &Store{history: make(map[string][]*Record)}
+
+	if len(fn) == 0 {
+		return s, err
+	}
+
+	if s.file, err = os.OpenFile(fn, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0640); err != nil {
+		return s, err
+	}
+
+	scanner := bufio.NewScanner(s.file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+
+		r := new(Record)
+
+		if err = json.Unmarshal(scanner.Bytes(), r); err != nil {
+			return s, err
+		}
+
+		s.insert(r)
+	}
+
+	return s, scanner.Err()
+}
+
+// Close closes the backing file, if any.
+func (s *Store) Close() (err error) {
+	if s.file != nil {
+		err = s.file.Close()
+	}
+	return err
+}
+
+// Add appends a record to the history of its reader.
+func (s *Store) Add(r *Record) (err error) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.file != nil {
+
+		b, err := json.Marshal(r)
+
+		if err != nil {
+			return err
+		}
+
+		if _, err = s.file.Write(append(b, '\n')); err != nil {
+			return err
+		}
+	}
+
+	s.insert(r)
+
+	return err
+}
+
+// History returns every record received for the reader with the given
+// factory serial number, oldest first.
+func (s *Store) History(fsn string) (rs []*Record) {
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return append(rs, s.history[fsn]...)
+}
+
+// Latest returns the most recent record of each reader for which the filter
+// function returns true.
+func (s *Store) Latest(filter func(*Record) bool) (rs []*Record) {
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	for _, h := range s.history {
+		if r := h[len(h)-1]; filter(r) {
+			rs = append(rs, r)
+		}
+	}
+
+	return rs
+}
+
+// insert adds a record to the in-memory history.
+func (s *Store) insert(r *Record) {
+	fsn := r.Info.FactorySN
+	s.history[fsn] = append(s.history[fsn], r)
+}
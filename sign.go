@@ -0,0 +1,124 @@
+package gomagtek
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+	"fmt"
+)
+
+const (
+	SignatureHeader string = "X-Magtek-Signature"
+	TimestampHeader string = "X-Magtek-Timestamp"
+	NonceHeader string = "X-Magtek-Nonce"
+
+	DefaultSignatureWindow time.Duration = 5 * time.Minute
+)
+
+// Signature computes the hex-encoded HMAC-SHA256 signature of a payload. The
+// timestamp and nonce are covered by the signature so that neither can be
+// altered to replay an old request, and so are the request method and path so
+// that a request cannot be replayed against another endpoint.
+func Signature(key, body []byte, method, path, timestamp, nonce string) string {
+
+	if len(path) == 0 {
+		path = "/"
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(method + "\n" + path + "\n" + timestamp + "\n" + nonce + "\n"))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignRequest adds timestamp, nonce, and signature headers to an outgoing
+// request carrying the given body.
+func SignRequest(req *http.Request, key, body []byte) (err error) {
+
+	nb := make([]byte, 16)
+
+	if _, err = rand.Read(nb); err != nil {
+		return fmt.Errorf("%s: %v", getFunctionInfo(), err)
+	}
+
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	nonce := hex.EncodeToString(nb)
+
+	req.Header.Set(TimestampHeader, ts)
+	req.Header.Set(NonceHeader, nonce)
+	req.Header.Set(SignatureHeader, Signature(key, body, req.Method, req.URL.Path, ts, nonce))
+
+	return err
+}
+
+// Verifier checks the signature headers of incoming requests. Requests are
+// rejected if the signature does not match, if the timestamp is outside the
+// allowed window, or if the nonce has already been seen within that window.
+type Verifier struct {
+	Key []byte
+	Window time.Duration
+	mutex sync.Mutex
+	nonces map[string]time.Time
+}
+
+// NewVerifier constructs a new Verifier.
+func NewVerifier(key []byte, window time.Duration) (*Verifier) {
+
+	if window <= 0 {
+		window = DefaultSignatureWindow
+	}
+
+	return &Verifier{Key: key, Window: window, nonces: make(map[string]time.Time)}
+}
+
+// Verify checks the signature of a request carrying the given body.
+func (v *Verifier) Verify(req *http.Request, body []byte) (err error) {
+
+	ts := req.Header.Get(TimestampHeader)
+	nonce := req.Header.Get(NonceHeader)
+	sig := req.Header.Get(SignatureHeader)
+
+	if len(ts) == 0 || len(nonce) == 0 || len(sig) == 0 {
+		return fmt.Errorf("%s: signature headers missing", getFunctionInfo())
+	}
+
+	secs, err := strconv.ParseInt(ts, 10, 64)
+
+	if err != nil {
+		return fmt.Errorf("%s: invalid timestamp: %s", getFunctionInfo(), ts)
+	}
+
+	now := time.Now()
+	sent := time.Unix(secs, 0)
+
+	if sent.Before(now.Add(-v.Window)) || sent.After(now.Add(v.Window)) {
+		return fmt.Errorf("%s: timestamp outside window: %s", getFunctionInfo(), ts)
+	}
+
+	if !hmac.Equal([]byte(sig), []byte(Signature(v.Key, body, req.Method, req.URL.Path, ts, nonce))) {
+		return fmt.Errorf("%s: signature mismatch", getFunctionInfo())
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	for n, t := range v.nonces {
+		if t.Before(now.Add(-v.Window)) {
+			delete(v.nonces, n)
+		}
+	}
+
+	if _, ok := v.nonces[nonce]; ok {
+		return fmt.Errorf("%s: replayed nonce: %s", getFunctionInfo(), nonce)
+	}
+
+	v.nonces[nonce] = sent
+
+	return err
+}
@@ -0,0 +1,187 @@
+package gomagtek
+
+import (
+	"path/filepath"
+	"io/ioutil"
+	"context"
+	"strings"
+	"sort"
+	"sync"
+	"time"
+	"fmt"
+	"os"
+)
+
+const (
+	DefaultSpoolLimit int = 1000
+	spoolFileExt string = ".json"
+)
+
+// Spool holds inventory payloads in a local directory while the collector is
+// unreachable. Each payload is stored in its own file, named after the time
+// the device was observed and its factory serial number, so that a directory
+// listing yields the payloads in the order they were observed. A payload of
+// the same device observed at the same time replaces the one spooled before
+// it, and the oldest payloads are discarded once the number of files exceeds
+// Limit. A Spool may be shared by uploads running in parallel; each payload is
+// sent only once.
+type Spool struct {
+	Dir string
+	Limit int
+	mutex sync.Mutex
+}
+
+// NewSpool constructs a new Spool, creating the spool directory if necessary.
+func NewSpool(dir string, limit int) (s *Spool, err error) {
+
+	if err = os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("%s: %v", getFunctionInfo(), err)
+	}
+
+	if limit <= 0 {
+		limit = DefaultSpoolLimit
+	}
+
+	return &Spool{Dir: dir, Limit: limit}, err
+}
+
+// Put writes the device information to the spool, replacing any payload of
+// the same device observed at the same time. Earlier observations of the
+// device are kept, so that the collector receives its full history. The
+// observation time of the payload is kept with it; if it has none, the
+// current time is used.
+func (s *Spool) Put(i *DeviceInfo) (err error) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	i = observed(i)
+	b, err := i.JSON(false)
+
+	if err != nil {
+		return fmt.Errorf("%s: %v", getFunctionInfo(), err)
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, i.Observed)
+
+	if err != nil {
+		t, err = time.Now(), nil
+	}
+
+	key := spoolKey(i.FactorySN)
+	fn := fmt.Sprintf("%020d-%s%s", t.UnixNano(), key, spoolFileExt)
+
+	if err = ioutil.WriteFile(filepath.Join(s.Dir, fn), b, 0640); err != nil {
+		return fmt.Errorf("%s: %v", getFunctionInfo(), err)
+	}
+
+	files, err := s.files()
+
+	if err != nil {
+		return fmt.Errorf("%s: %v", getFunctionInfo(), err)
+	}
+
+	// The file is named after the factory serial number and observation
+	// time, so a duplicate has already been overwritten.
+
+	for len(files) > s.Limit {
+		os.Remove(filepath.Join(s.Dir, files[0]))
+		files = files[1:]
+	}
+
+	return err
+}
+
+// Len returns the number of payloads in the spool.
+func (s *Spool) Len() (int, error) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	files, err := s.files()
+	return len(files), err
+}
+
+// Drain uploads spooled payloads in the order they were observed, removing
+// each one after it is accepted or rejected as malformed by the collector. It
+// stops at the first failure worth retrying, or at the first payload refused
+// for want of authorization, so that the remaining payloads keep their order
+// for the next attempt.
+func (s *Spool) Drain(u *Uploader) ([]UploadResult, error) {
+	return s.DrainContext(context.Background(), u)
+}
+
+// DrainContext is Drain with a context. Draining stops when the context is
+// done, leaving the remaining payloads in the spool.
+func (s *Spool) DrainContext(ctx context.Context, u *Uploader) (rs []UploadResult, err error) {
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	files, err := s.files()
+
+	if err != nil {
+		return rs, fmt.Errorf("%s: %v", getFunctionInfo(), err)
+	}
+
+	for _, f := range files {
+
+		fn := filepath.Join(s.Dir, f)
+		b, err := ioutil.ReadFile(fn)
+
+		if err != nil {
+			return rs, fmt.Errorf("%s: %v", getFunctionInfo(), err)
+		}
+
+		i, err := NewDeviceInfoFromJSON(b)
+
+		if err != nil {
+			os.Remove(fn)
+			continue
+		}
+
+		r := u.send(ctx, i)
+		rs = append(rs, r)
+
+		if r.Error != nil && r.keep() {
+			return rs, r.Error
+		}
+
+		os.Remove(fn)
+	}
+
+	return rs, err
+}
+
+// files returns the names of the spooled payload files, oldest first.
+func (s *Spool) files() (files []string, err error) {
+
+	fis, err := ioutil.ReadDir(s.Dir)
+
+	if err != nil {
+		return files, err
+	}
+
+	for _, fi := range fis {
+		if !fi.IsDir() && strings.HasSuffix(fi.Name(), spoolFileExt) {
+			files = append(files, fi.Name())
+		}
+	}
+
+	sort.Strings(files)
+
+	return files, err
+}
+
+// spoolKey converts a factory serial number into a string that is safe to use
+// in a filename.
+func spoolKey(sn string) string {
+
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= '0' && r <= '9', r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z':
+			return r
+		}
+		return '_'
+	}, sn)
+}
@@ -0,0 +1,232 @@
+package gomagtek
+
+import (
+	"encoding/json"
+	"context"
+	"strings"
+	"math"
+	"fmt"
+)
+
+// DesiredState describes how a device should be configured. Models lists
+// the product IDs the state may be applied to; an empty list allows any
+// model. Properties maps property names or IDs to values: text for string
+// properties and decimal or 0x-prefixed hex numbers for byte properties.
+type DesiredState struct {
+	Models []string
+	DeviceSN *SerialRule
+	Properties map[string]string
+}
+
+// SerialRule determines the desired device serial number. Exactly one of
+// Erase, Value, Template, or Copy should be set. If OnlyIfEmpty is true, a
+// device that already has a serial number is left alone.
+type SerialRule struct {
+	Erase bool
+	Value string
+	Template string
+	Copy int
+	Vars map[string]string
+	Counter int
+	OnlyIfEmpty bool
+}
+
+// NewDesiredStateFromJSON decodes a desired state.
+func NewDesiredStateFromJSON(j []byte) (ds *DesiredState, err error) {
+
+	ds = new(DesiredState)
+
+	if err = json.Unmarshal(j, ds); err != nil {
+		err = fmt.Errorf("%s: %w", getFunctionInfo(), err)
+	}
+
+	return ds, err
+}
+
+// Plan compares the desired state with the device and returns the changes
+// needed to bring the device into that state.
+func (d *Device) Plan(ds *DesiredState) ([]PropertyChange, error) {
+	return d.PlanContext(context.Background(), ds)
+}
+
+// PlanContext is Plan with a context.
+func (d *Device) PlanContext(ctx context.Context, ds *DesiredState) (pcs []PropertyChange, err error) {
+
+	if len(ds.Models) > 0 && !containsFold(ds.Models, d.GetProductID()) {
+		return pcs, fmt.Errorf("%s: product ID %s not in allowed models %v",
+			getFunctionInfo(), d.GetProductID(), ds.Models)
+	}
+
+	if ds.DeviceSN != nil {
+
+		sn, err := d.GetDeviceSNContext(ctx)
+
+		if err != nil {
+			return pcs, fmt.Errorf("%s: %w", getFunctionInfo(), err)
+		}
+
+		want, err := d.desiredSN(ctx, ds.DeviceSN, sn)
+
+		if err != nil {
+			return pcs, err
+		}
+
+		if want != sn {
+			p, _ := LookupProperty("device_sn")
+			pcs = append(pcs, PropertyChange{p, sn, want})
+		}
+	}
+
+	values := make(map[uint8]string)
+
+	for key, value := range ds.Properties {
+
+		p, ok := LookupProperty(key)
+
+		if !ok {
+			return pcs, fmt.Errorf("%s: unknown property %s", getFunctionInfo(), key)
+		}
+
+		values[p.ID] = value
+	}
+
+	for _, p := range Properties {
+
+		value, ok := values[p.ID]
+
+		if !ok {
+			continue
+		}
+
+		if !p.Writable || p.ID == PropDeviceSN {
+			return pcs, fmt.Errorf("%s: property %s cannot be set", getFunctionInfo(), p.Name)
+		}
+
+		want, err := p.Parse(value)
+
+		if err != nil {
+			return pcs, fmt.Errorf("%s: %w", getFunctionInfo(), err)
+		}
+
+		old, err := d.getProperty(ctx, p.ID)
+
+		if err != nil {
+			return pcs, fmt.Errorf("%s: property %s: %w", getFunctionInfo(), p.Name, err)
+		}
+
+		if old != want {
+			pcs = append(pcs, PropertyChange{p, old, want})
+		}
+	}
+
+	return pcs, err
+}
+
+// Apply plans the desired state and writes only the properties that differ.
+// It returns the changes that were applied before any failure.
+func (d *Device) Apply(ds *DesiredState) ([]PropertyChange, error) {
+	return d.ApplyContext(context.Background(), ds)
+}
+
+// ApplyContext is Apply with a context. No further writes are started once
+// the context is done.
+func (d *Device) ApplyContext(ctx context.Context, ds *DesiredState) (applied []PropertyChange, err error) {
+
+	pcs, err := d.PlanContext(ctx, ds)
+
+	if err != nil {
+		return applied, err
+	}
+
+	return d.applyChanges(ctx, "Apply", pcs)
+}
+
+// applyChanges writes each change with verification on behalf of the named
+// operation, stopping at the first failure or when the context is done.
+// Device serial numbers are checked against the serial policy.
+func (d *Device) applyChanges(ctx context.Context, op string, pcs []PropertyChange) (applied []PropertyChange, err error) {
+
+	for _, pc := range pcs {
+
+		if err = ctx.Err(); err != nil {
+			return applied, fmt.Errorf("%s: %w", getFunctionInfo(), err)
+		}
+
+		if pc.ID == PropDeviceSN {
+			err = d.setDeviceSN(ctx, op, pc.New)
+		} else {
+			err = d.auditedWrite(ctx, op, pc.ID, pc.New)
+		}
+
+		if err != nil {
+			return applied, fmt.Errorf("%s: property %s: %w", getFunctionInfo(), pc.Name, err)
+		}
+
+		applied = append(applied, pc)
+	}
+
+	return applied, err
+}
+
+// desiredSN computes the serial number the rule calls for, given the current
+// serial number of the device.
+func (d *Device) desiredSN(ctx context.Context, sr *SerialRule, current string) (sn string, err error) {
+
+	if sr.Erase {
+		return "", err
+	}
+
+	if sr.OnlyIfEmpty && len(current) > 0 {
+		return current, err
+	}
+
+	switch {
+
+	case len(sr.Value) > 0:
+		return sr.Value, err
+
+	case len(sr.Template) > 0:
+
+		t, err := NewSerialTemplate(sr.Template)
+
+		if err != nil {
+			return sn, err
+		}
+
+		c, err := d.serialContext(ctx, SerialContext{Counter: sr.Counter, Vars: sr.Vars})
+
+		if err != nil {
+			return sn, err
+		}
+
+		return t.Execute(&c)
+
+	case sr.Copy > 0:
+
+		fs, err := d.GetFactorySNContext(ctx)
+
+		if err != nil {
+			return sn, fmt.Errorf("%s: %w", getFunctionInfo(), err)
+		}
+
+		if len(fs) == 0 {
+			return sn, fmt.Errorf("%s: factory serial number not present", getFunctionInfo())
+		}
+
+		return fs[:int(math.Min(float64(sr.Copy), float64(len(fs))))], err
+	}
+
+	return current, err
+}
+
+// containsFold reports whether list contains s, ignoring case.
+func containsFold(list []string, s string) bool {
+
+	for _, l := range list {
+		if strings.EqualFold(l, s) {
+			return true
+		}
+	}
+
+	return false
+}
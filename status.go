@@ -0,0 +1,94 @@
+package gomagtek
+
+import (
+	"github.com/google/gousb"
+	"context"
+	"fmt"
+)
+
+// Serial number status values.
+const (
+	SerialInSync string = "in_sync"
+	SerialNeedsReset string = "needs_reset"
+	SerialUnknown string = "unknown"
+)
+
+// SerialStatus compares the device serial number in NVRAM with the serial
+// number in the USB device descriptor. The descriptor is built from NVRAM
+// when the device starts, so after the device serial number is changed the
+// two differ until the device is reset or power-cycled. Hosts and inventory
+// tools that read the descriptor see the old serial number in the meantime.
+type SerialStatus struct {
+	DeviceSN string
+	DescriptSN string
+	NeedsReset bool
+}
+
+// SerialStatus retrieves the device and descriptor serial numbers and reports
+// whether they differ.
+func (d *Device) SerialStatus() (*SerialStatus, error) {
+	return d.SerialStatusContext(context.Background())
+}
+
+// SerialStatusContext is SerialStatus with a context.
+func (d *Device) SerialStatusContext(ctx context.Context) (ss *SerialStatus, err error) {
+
+	ss = new(SerialStatus)
+
+	if ss.DeviceSN, err = d.GetDeviceSNContext(ctx); err != nil {
+		return nil, fmt.Errorf("%s: %w", getFunctionInfo(), err)
+	}
+
+	if ss.DescriptSN, err = d.GetDescriptSNContext(ctx); err != nil {
+		return nil, fmt.Errorf("%s: %w", getFunctionInfo(), err)
+	}
+
+	ss.NeedsReset = ss.DeviceSN != ss.DescriptSN
+
+	return ss, err
+}
+
+// String returns SerialInSync or SerialNeedsReset, or SerialUnknown if the
+// status is nil because it could not be determined.
+func (ss *SerialStatus) String() string {
+
+	switch {
+
+	case ss == nil:
+		return SerialUnknown
+
+	case ss.NeedsReset:
+		return SerialNeedsReset
+	}
+
+	return SerialInSync
+}
+
+// SyncSerial resets the device if its descriptor serial number differs from
+// its device serial number, and returns the Device to use afterwards: the
+// Device itself if no reset was needed, or a newly opened Device otherwise, as
+// with DeviceResetReopen. An error is returned if the serial numbers still
+// differ after the reset, in which case the device must be power-cycled.
+func (d *Device) SyncSerial(ctx context.Context, uc *gousb.Context) (nd *Device, err error) {
+
+	ss, err := d.SerialStatusContext(ctx)
+
+	if err != nil || !ss.NeedsReset {
+		return d, err
+	}
+
+	if nd, err = d.DeviceResetReopen(ctx, uc); err != nil {
+		return nil, fmt.Errorf("%s: %w", getFunctionInfo(), err)
+	}
+
+	if ss, err = nd.SerialStatusContext(ctx); err != nil {
+		return nd, fmt.Errorf("%s: %w", getFunctionInfo(), err)
+	}
+
+	if ss.NeedsReset {
+		err = fmt.Errorf("%s: descriptor serial number %q still differs from device serial number %q: power cycle required",
+			getFunctionInfo(), ss.DescriptSN, ss.DeviceSN)
+	}
+
+	return nd, err
+}
@@ -0,0 +1,226 @@
+package gomagtek
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"regexp"
+	"fmt"
+	"os"
+)
+
+// SerialTemplate computes device serial numbers from a template string made
+// up of literal text and placeholders in braces. Supported placeholders are:
+//
+//	{factory}		factory serial number
+//	{factory[a:b]}		characters a through b-1 of the factory serial number
+//	{host}			host name, also sliceable as {host[a:b]}
+//	{host.n}		n-th part of the host name split on '-', '.', or '_'
+//	{counter}		counter value, zero-padded to w digits as {counter:w}
+//	{check}			check character over all preceding characters
+//	{name}			value of variable 'name', such as {site}
+//
+// Slices are clamped to the length of the value, so {factory[0:7]} behaves
+// like CopyFactorySN(7).
+type SerialTemplate struct {
+	Text string
+	CheckDigit CheckDigitFunc
+	tokens []templateToken
+}
+
+// SerialContext supplies the values referenced by template placeholders.
+type SerialContext struct {
+	FactorySN string
+	HostName string
+	Counter int
+	Vars map[string]string
+}
+
+type templateToken struct {
+	literal string
+	name string
+	slice bool
+	from, to int
+	part int
+	width int
+}
+
+var placeholderRegex = regexp.MustCompile(
+	`^([A-Za-z_][A-Za-z0-9_]*)(?:\[(\d*):(\d*)\]|\.(\d+)|:(\d+))?$`)
+
+// NewSerialTemplate parses a template string. The check character is
+// computed with LuhnMod36 unless CheckDigit is changed.
+func NewSerialTemplate(text string) (t *SerialTemplate, err error) {
+
+	t = &SerialTemplate{Text: text, CheckDigit: LuhnMod36}
+
+	for rest := text; len(rest) > 0; {
+
+		open := strings.IndexByte(rest, '{')
+
+		if open < 0 {
+			t.tokens = append(t.tokens, templateToken{literal: rest})
+			break
+		}
+
+		if open > 0 {
+			t.tokens = append(t.tokens, templateToken{literal: rest[:open]})
+		}
+
+		end := strings.IndexByte(rest[open:], '}')
+
+		if end < 0 {
+			return nil, fmt.Errorf("%s: unterminated placeholder in %q", getFunctionInfo(), text)
+		}
+
+		tok, err := parsePlaceholder(rest[open+1:open+end])
+
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", getFunctionInfo(), err)
+		}
+
+		t.tokens = append(t.tokens, tok)
+		rest = rest[open+end+1:]
+	}
+
+	return t, err
+}
+
+// Execute computes a serial number from the template and context.
+func (t *SerialTemplate) Execute(c *SerialContext) (sn string, err error) {
+
+	for _, tok := range t.tokens {
+
+		if tok.name == "" {
+			sn += tok.literal
+			continue
+		}
+
+		var value string
+
+		switch tok.name {
+
+		case "factory":
+			value = c.FactorySN
+
+		case "host":
+			value = c.HostName
+
+		case "counter":
+			value = fmt.Sprintf("%0*d", tok.width, c.Counter)
+
+		case "check":
+			if t.CheckDigit == nil {
+				return sn, fmt.Errorf("%s: no check digit function", getFunctionInfo())
+			}
+			cd, err := t.CheckDigit(sn)
+			if err != nil {
+				return sn, fmt.Errorf("%s: %w", getFunctionInfo(), err)
+			}
+			value = string(cd)
+
+		default:
+			var ok bool
+			if value, ok = c.Vars[tok.name]; !ok {
+				return sn, fmt.Errorf("%s: undefined variable %q", getFunctionInfo(), tok.name)
+			}
+		}
+
+		if tok.part >= 0 {
+
+			parts := strings.FieldsFunc(value, func(r rune) bool {
+				return r == '-' || r == '.' || r == '_'
+			})
+
+			if tok.part >= len(parts) {
+				return sn, fmt.Errorf("%s: %q has no part %d", getFunctionInfo(), value, tok.part)
+			}
+
+			value = parts[tok.part]
+		}
+
+		if tok.slice {
+			from, to := tok.from, tok.to
+			if to < 0 || to > len(value) {to = len(value)}
+			if from > to {from = to}
+			value = value[from:to]
+		}
+
+		sn += value
+	}
+
+	return sn, err
+}
+
+// ApplySerialTemplate computes the device serial number from the template and
+// writes it to device NVRAM. The factory serial number and host name are
+// retrieved automatically when not supplied in the context.
+func (d *Device) ApplySerialTemplate(t *SerialTemplate, c SerialContext) (string, error) {
+	return d.ApplySerialTemplateContext(context.Background(), t, c)
+}
+
+// ApplySerialTemplateContext is ApplySerialTemplate with a context.
+func (d *Device) ApplySerialTemplateContext(ctx context.Context, t *SerialTemplate, c SerialContext) (sn string, err error) {
+
+	if c, err = d.serialContext(ctx, c); err != nil {
+		return sn, err
+	}
+
+	if sn, err = t.Execute(&c); err != nil {
+		return sn, err
+	}
+
+	return sn, d.SetDeviceSNContext(ctx, sn)
+}
+
+// serialContext fills in the factory serial number and host name of the
+// context when they are not already set.
+func (d *Device) serialContext(ctx context.Context, c SerialContext) (SerialContext, error) {
+
+	var err error
+
+	if len(c.FactorySN) == 0 {
+		if c.FactorySN, err = d.GetFactorySNContext(ctx); err != nil {
+			return c, fmt.Errorf("%s: %w", getFunctionInfo(), err)
+		}
+	}
+
+	if len(c.HostName) == 0 {
+		if c.HostName, err = os.Hostname(); err != nil {
+			return c, fmt.Errorf("%s: %w", getFunctionInfo(), err)
+		}
+	}
+
+	return c, err
+}
+
+// parsePlaceholder converts the contents of a placeholder into a token.
+func parsePlaceholder(s string) (tok templateToken, err error) {
+
+	m := placeholderRegex.FindStringSubmatch(s)
+
+	if m == nil {
+		return tok, fmt.Errorf("invalid placeholder {%s}", s)
+	}
+
+	tok = templateToken{name: m[1], to: -1, part: -1}
+
+	if strings.Contains(s, "[") {
+		tok.slice = true
+		if len(m[2]) > 0 {tok.from, _ = strconv.Atoi(m[2])}
+		if len(m[3]) > 0 {tok.to, _ = strconv.Atoi(m[3])}
+	}
+
+	if len(m[4]) > 0 {
+		tok.part, _ = strconv.Atoi(m[4])
+	}
+
+	if len(m[5]) > 0 {
+		if tok.name != "counter" {
+			return tok, fmt.Errorf("width not supported in placeholder {%s}", s)
+		}
+		tok.width, _ = strconv.Atoi(m[5])
+	}
+
+	return tok, err
+}
@@ -0,0 +1,194 @@
+package gomagtek
+
+import (
+	"context"
+	"io"
+	"time"
+	"fmt"
+)
+
+// Transport carries control transfers between a Device and the reader. The
+// default transport is the underlying gousb device; another can be set in the
+// Device Transport field, for example to stand in for a reader in tests.
+type Transport interface {
+	Control(rType, request uint8, val, idx uint16, data []byte) (int, error)
+}
+
+// transport returns the transport in use by the device.
+func (d *Device) transport() (Transport) {
+
+	if d.Transport != nil {
+		return d.Transport
+	}
+
+	return d.Device
+}
+
+// Close releases the device: the claimed interface, if any, reattaching the
+// kernel driver if it was detached; the transport, if it can be closed; and
+// the gousb device, if it was opened through libusb. Each step is attempted
+// even if an earlier one fails, and the first error is returned.
+func (d *Device) Close() (err error) {
+
+	d.cmdMutex.Lock()
+	err = d.release()
+	d.cmdMutex.Unlock()
+
+	if c, ok := d.Transport.(io.Closer); ok {
+		if e := c.Close(); err == nil {
+			err = e
+		}
+	}
+
+	if d.usb {
+		if e := d.Device.Close(); err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
+
+// command sends a vendor command to the device with SET_REPORT and reads the
+// response with GET_REPORT, retrying transient failures according to the
+// device retry policy. The first byte of the response is the result code; a
+// non-zero result code is returned as an error along with the data.
+func (d *Device) command(ctx context.Context, cmd []byte) (data []byte, err error) {
+	return d.commandPolicy(ctx, d.RetryPolicy, cmd)
+}
+
+// commandPolicy is command with the given retry policy.
+func (d *Device) commandPolicy(ctx context.Context, rp *RetryPolicy, cmd []byte) (data []byte, err error) {
+
+	if err = d.ensureBufferSize(); err != nil {
+		return nil, fmt.Errorf("%s: %w", getFunctionInfo(), err)
+	}
+
+	out := make([]byte, d.BufferSize)
+	copy(out, cmd)
+
+	data = make([]byte, d.BufferSize)
+
+	if _, err = d.exchangeRetry(ctx, rp, out, data); err != nil {
+		return nil, fmt.Errorf("%s: %w", getFunctionInfo(), err)
+	}
+
+	if data[0] > 0x00 {
+		err = fmt.Errorf("%s: command error: %d", getFunctionInfo(), int(data[0]))
+	}
+
+	return data, err
+}
+
+// exchange writes a feature report with SET_REPORT and reads the response
+// into in with GET_REPORT, returning the number of bytes read. The pair is
+// performed while holding the command lock, so that a response can never be
+// read by a goroutine other than the one that sent the command.
+func (d *Device) exchange(ctx context.Context, out, in []byte) (n int, err error) {
+
+	d.cmdMutex.Lock()
+	defer d.cmdMutex.Unlock()
+
+	err = d.transfer(ctx, func() (e error) {
+		_, e = d.transport().Control(
+			RequestDirectionOut + RequestTypeClass + RequestRecipientDevice,
+			RequestSetReport,
+			TypeFeatureReport,
+			InterfaceNumber,
+			out)
+		return e
+	})
+
+	if err != nil {
+		return n, err
+	}
+
+	err = d.transfer(ctx, func() (e error) {
+		n, e = d.transport().Control(
+			RequestDirectionIn + RequestTypeClass + RequestRecipientDevice,
+			RequestGetReport,
+			TypeFeatureReport,
+			InterfaceNumber,
+			in)
+		return e
+	})
+
+	return n, err
+}
+
+// control performs a single control transfer while holding the command lock.
+func (d *Device) control(ctx context.Context, rType, request uint8, val, idx uint16, data []byte) (n int, err error) {
+
+	d.cmdMutex.Lock()
+	defer d.cmdMutex.Unlock()
+
+	err = d.transfer(ctx, func() (e error) {
+		n, e = d.transport().Control(rType, request, val, idx, data)
+		return e
+	})
+
+	return n, err
+}
+
+// stringDescriptor retrieves a string descriptor while holding the command
+// lock, if the transport can provide string descriptors.
+func (d *Device) stringDescriptor(ctx context.Context, idx uint8) (value string, err error) {
+
+	d.cmdMutex.Lock()
+	defer d.cmdMutex.Unlock()
+
+	sd, ok := d.transport().(stringDescriptorer)
+
+	if !ok {
+		return value, fmt.Errorf("%s: string descriptors not supported by transport", getFunctionInfo())
+	}
+
+	err = d.transfer(ctx, func() (e error) {
+		value, e = sd.GetStringDescriptor(int(idx))
+		return e
+	})
+
+	return value, err
+}
+
+// stringDescriptorer is implemented by transports that provide string
+// descriptors, such as the gousb device.
+type stringDescriptorer interface {
+	GetStringDescriptor(idx int) (string, error)
+}
+
+// transfer runs f with the control transfer timeout of the underlying gousb
+// device set from the device Timeout and the context deadline, whichever comes
+// first, then restores it. A canceled context stops further transfers but
+// cannot interrupt a transfer already in progress; if f fails after the
+// context is done, the context error is returned instead. USB errors are
+// returned with their category. The caller must hold the command lock.
+func (d *Device) transfer(ctx context.Context, f func() error) (err error) {
+
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	timeout := d.Timeout
+
+	if dl, ok := ctx.Deadline(); ok {
+		if left := time.Until(dl); timeout <= 0 || left < timeout {
+			timeout = left
+		}
+	}
+
+	if timeout > 0 && d.Device != nil {
+		defer func(t time.Duration) {d.ControlTimeout = t}(d.ControlTimeout)
+		d.ControlTimeout = timeout
+	}
+
+	if err = f(); err != nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+
+	if err != nil {
+		err = classify(err)
+	}
+
+	return err
+}
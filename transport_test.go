@@ -0,0 +1,102 @@
+package gomagtek
+
+import (
+	"runtime"
+	"testing"
+	"sync"
+)
+
+// fakeTransport answers GET_PROP commands from a property table. It keeps
+// the command of the last SET_REPORT for the GET_REPORT that follows, as the
+// reader does, so a response read by the wrong caller shows up as the value
+// of another property.
+type fakeTransport struct {
+	mutex sync.Mutex
+	props map[uint8]string
+	pending []byte
+}
+
+func (f *fakeTransport) Control(rType, request uint8, val, idx uint16, data []byte) (int, error) {
+
+	f.mutex.Lock()
+
+	switch request {
+
+	case RequestSetReport:
+		f.pending = append([]byte(nil), data...)
+		f.mutex.Unlock()
+
+		// Give other goroutines a chance to slip in between the
+		// command and its response.
+
+		runtime.Gosched()
+
+		return len(data), nil
+
+	case RequestGetReport:
+		defer f.mutex.Unlock()
+
+		for i := range data {
+			data[i] = 0
+		}
+
+		if len(f.pending) > 2 && f.pending[0] == CommandGetProp {
+			v := f.props[f.pending[2]]
+			data[1] = uint8(len(v))
+			copy(data[2:], v)
+		}
+
+		return len(data), nil
+	}
+
+	f.mutex.Unlock()
+
+	return 0, nil
+}
+
+func newFakeDevice(props map[uint8]string) (*Device) {
+
+	return &Device {
+		BufferSize: BufferSizeSureswipe,
+		DeviceDescriptor: new(DeviceDescriptor),
+		ConfigDescriptor: new(ConfigDescriptor),
+		RetryPolicy: NewRetryPolicy(),
+		Transport: &fakeTransport{props: props}}
+}
+
+// TestConcurrentCommands checks that every caller gets the response to its
+// own command when commands are sent from many goroutines at once. Run it
+// with -race.
+func TestConcurrentCommands(t *testing.T) {
+
+	d := newFakeDevice(map[uint8]string {
+		PropDeviceSN: "DEVSN01",
+		PropSoftwareID: "21042840G01"})
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 100; i++ {
+
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+			if v, err := d.GetDeviceSN(); err != nil || v != "DEVSN01" {
+				t.Errorf("GetDeviceSN() = %q, %v; want %q", v, err, "DEVSN01")
+			}
+		}()
+
+		go func() {
+			defer wg.Done()
+			if v, err := d.GetSoftwareID(); err != nil || v != "21042840G01" {
+				t.Errorf("GetSoftwareID() = %q, %v; want %q", v, err, "21042840G01")
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if s := d.Stats(); s.Commands != 200 || s.Failures != 0 {
+		t.Errorf("Stats() = %+v; want 200 commands and no failures", s)
+	}
+}
@@ -0,0 +1,57 @@
+package gomagtek
+
+import (
+	"strings"
+	"fmt"
+	"os"
+)
+
+const (
+	DefaultUdevRulesFile string = "/etc/udev/rules.d/99-magtek.rules"
+	DefaultUdevGroup string = "plugdev"
+	DefaultUdevMode os.FileMode = 0660
+)
+
+// KnownProductIDs lists the distinct product IDs of the supported readers.
+var KnownProductIDs = []uint16 {
+	SureswipeKbPID,
+	SureswipeHidPID,
+	MagnesafeSwipeHidPID,
+	MagnesafeInsertHidPID,
+	MagnesafeWirelessHidPID}
+
+// AccessDiagnosis describes whether the current user can open the device
+// node of a Magtek reader and, if not, why not.
+type AccessDiagnosis struct {
+	Path string
+	BusNumber int
+	BusAddress int
+	ProductID string
+	Owner string
+	Group string
+	Mode os.FileMode
+	CanOpen bool
+	Problems []string
+}
+
+// UdevRules generates a udev rules file that gives members of the group
+// read/write access, with the given mode, to the USB and hidraw device nodes
+// of readers with MagtekVendorID and one of the KnownProductIDs. Install it as
+// DefaultUdevRulesFile, then reload the rules and replug the readers.
+func UdevRules(group string, mode os.FileMode) (string) {
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# Magtek card readers: allow members of group %s to open them without root.\n", group)
+	fmt.Fprintf(&b, "# Install as %s, then run 'udevadm control --reload-rules'\n", DefaultUdevRulesFile)
+	fmt.Fprintf(&b, "# and 'udevadm trigger', or replug the readers.\n")
+
+	for _, pid := range KnownProductIDs {
+		fmt.Fprintf(&b, "SUBSYSTEM==\"usb\", ATTR{idVendor}==\"%04x\", ATTR{idProduct}==\"%04x\", MODE=\"%04o\", GROUP=\"%s\"\n",
+			MagtekVendorID, pid, mode.Perm(), group)
+		fmt.Fprintf(&b, "SUBSYSTEM==\"hidraw\", ATTRS{idVendor}==\"%04x\", ATTRS{idProduct}==\"%04x\", MODE=\"%04o\", GROUP=\"%s\"\n",
+			MagtekVendorID, pid, mode.Perm(), group)
+	}
+
+	return b.String()
+}
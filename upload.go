@@ -0,0 +1,251 @@
+package gomagtek
+
+import (
+	"net/http"
+	"context"
+	"strings"
+	"bytes"
+	"time"
+	"fmt"
+)
+
+const (
+	DefaultUploadRetries int = 3
+	DefaultUploadBackoff time.Duration = 2 * time.Second
+	DefaultUploadTimeout time.Duration = 30 * time.Second
+)
+
+// Uploader sends device inventory information to a collector at an HTTP
+// endpoint. Payloads are encoded as JSON or XML according to Format. Failed
+// uploads are retried up to Retries times, doubling the Backoff interval
+// after each attempt. When Spool is set, payloads that cannot be delivered are
+// written to the spool and sent ahead of new payloads once the collector is
+// reachable again. When Key is set, every request is signed with it. Every
+// payload carries the time the device was observed, so that payloads
+// delivered late from the spool are not mistaken for recent ones.
+type Uploader struct {
+	URL string
+	Format string
+	Token string
+	Key []byte
+	Headers map[string]string
+	Retries int
+	Backoff time.Duration
+	Client *http.Client
+	Spool *Spool
+}
+
+// UploadResult represents the outcome of uploading the inventory information
+// of a single device.
+type UploadResult struct {
+	FactorySN string
+	DeviceSN string
+	Status int
+	Attempts int
+	Spooled bool
+	Error error
+	retry bool
+}
+
+// NewUploader constructs a new Uploader with default settings.
+func NewUploader(url string) (*Uploader) {
+
+	return &Uploader {
+		URL: url,
+		Format: "json",
+		Headers: make(map[string]string),
+		Retries: DefaultUploadRetries,
+		Backoff: DefaultUploadBackoff,
+		Client: &http.Client{Timeout: DefaultUploadTimeout}}
+}
+
+// Upload sends the inventory information of a single device to the collector.
+// If a spool is configured, spooled payloads are delivered first to preserve
+// their order, and the payload is spooled instead if the collector cannot be
+// reached.
+func (u *Uploader) Upload(i *DeviceInfo) (UploadResult) {
+	return u.UploadContext(context.Background(), i)
+}
+
+// UploadContext is Upload with a context. Requests and the waits between
+// attempts end when the context is done, and the payload is spooled as if
+// the collector could not be reached.
+func (u *Uploader) UploadContext(ctx context.Context, i *DeviceInfo) (r UploadResult) {
+
+	i = observed(i)
+
+	if u.Spool == nil {
+		return u.send(ctx, i)
+	}
+
+	if n, _ := u.Spool.Len(); n > 0 {
+		_, r.Error = u.Spool.DrainContext(ctx, u)
+		r.retry = true
+	}
+
+	if r.Error == nil {
+		r = u.send(ctx, i)
+	} else {
+		r.FactorySN, r.DeviceSN = i.FactorySN, i.DeviceSN
+	}
+
+	if r.Error != nil && r.keep() {
+		if err := u.Spool.Put(i); err != nil {
+			r.Error = fmt.Errorf("%v; %v", r.Error, err)
+		} else {
+			r.Spooled = true
+		}
+	}
+
+	return r
+}
+
+// send delivers the payload to the collector, retrying with backoff until
+// the context is done.
+func (u *Uploader) send(ctx context.Context, i *DeviceInfo) (r UploadResult) {
+
+	r = UploadResult{FactorySN: i.FactorySN, DeviceSN: i.DeviceSN}
+
+	body, ctype, err := u.encode(i)
+
+	if err != nil {
+		r.Error = fmt.Errorf("%s: %v", getFunctionInfo(), err)
+		return r
+	}
+
+	backoff := u.Backoff
+
+	for r.Attempts = 1; ; r.Attempts++ {
+
+		r.Status, r.retry, r.Error = u.post(ctx, body, ctype)
+
+		if r.Error == nil || !r.retry || r.Attempts > u.Retries || ctx.Err() != nil {
+			break
+		}
+
+		select {
+
+		case <-ctx.Done():
+			r.Error = fmt.Errorf("%s: %v", getFunctionInfo(), ctx.Err())
+			return r
+
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+	}
+
+	if r.Error != nil {
+		r.Error = fmt.Errorf("%s: %v", getFunctionInfo(), r.Error)
+	}
+
+	return r
+}
+
+// keep reports whether a failed upload should be kept for another attempt:
+// failures worth retrying, and payloads refused for want of authorization,
+// which may be accepted once the token, key, or clock is corrected.
+func (r UploadResult) keep() bool {
+	return r.retry || r.Status == http.StatusUnauthorized || r.Status == http.StatusForbidden
+}
+
+// observed returns the device information with its observation time set to
+// the current time if it has none. The caller's copy is not modified.
+func observed(i *DeviceInfo) (*DeviceInfo) {
+
+	if len(i.Observed) > 0 {
+		return i
+	}
+
+	c := *i
+	c.Observed = time.Now().UTC().Format(time.RFC3339Nano)
+
+	return &c
+}
+
+// UploadAll sends the inventory information of multiple devices to the
+// collector and returns the result for each device in the same order.
+func (u *Uploader) UploadAll(is []*DeviceInfo) (rs []UploadResult) {
+
+	for _, i := range is {
+		rs = append(rs, u.Upload(i))
+	}
+
+	return rs
+}
+
+// encode serializes the device information in the configured format and
+// returns the payload along with its content type.
+func (u *Uploader) encode(i *DeviceInfo) (body []byte, ctype string, err error) {
+
+	switch strings.ToLower(u.Format) {
+
+	case "", "json":
+		body, err = i.JSON(false)
+		ctype = "application/json"
+
+	case "xml":
+		body, err = i.XML(false)
+		ctype = "application/xml"
+
+	default:
+		err = fmt.Errorf("unsupported format: %s", u.Format)
+	}
+
+	return body, ctype, err
+}
+
+// post performs a single upload attempt. It reports whether a failed attempt
+// is worth retrying: transport errors and server-side errors are, while
+// client-side errors such as a rejected token are not.
+func (u *Uploader) post(ctx context.Context, body []byte, ctype string) (status int, retry bool, err error) {
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.URL, bytes.NewReader(body))
+
+	if err != nil {
+		return status, false, err
+	}
+
+	req.Header.Set("Content-Type", ctype)
+
+	for k, v := range u.Headers {
+		req.Header.Set(k, v)
+	}
+
+	if len(u.Token) > 0 {
+		req.Header.Set("Authorization", "Bearer " + u.Token)
+	}
+
+	if len(u.Key) > 0 {
+		if err = SignRequest(req, u.Key, body); err != nil {
+			return status, false, err
+		}
+	}
+
+	client := u.Client
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+
+	if err != nil {
+		return status, true, err
+	}
+
+	defer resp.Body.Close()
+	status = resp.StatusCode
+
+	switch {
+
+	case status >= 200 && status < 300:
+		return status, false, nil
+
+	case status >= 500, status == http.StatusTooManyRequests:
+		return status, true, fmt.Errorf("server error: %s", resp.Status)
+
+	default:
+		return status, false, fmt.Errorf("upload rejected: %s", resp.Status)
+	}
+}
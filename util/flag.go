@@ -5,7 +5,9 @@ import (
 	"strings"
 	"strconv"
 	"flag"
+	"time"
 	"fmt"
+	"os"
 )
 
 // stringValue is a string flag value that knows if it has been set.
@@ -68,53 +70,181 @@ func (rf *reportFields) IsSet() bool {
 	return rf.set
 }
 
-var includeUsage = "Include `<fields>` in report (comma-separated list):"
+var includeUsage = "Include `<fields>` in report (comma-separated list, default all):"
 var formatUsage = "Write report output in `<format>` format:"
 
+// command describes a util subcommand. Commands that need an option refuse
+// to run without one, since they would otherwise do nothing.
+type command struct {
+	flags *flag.FlagSet
+	summary string
+	needsOption bool
+}
+
+var commandNames = []string{"list", "info", "report", "config", "reset", "backup", "restore", "doctor"}
+
+var commands map[string]*command
+
 var (
-	fsMode = flag.NewFlagSet("mode", flag.ExitOnError)
-	fModeReport = fsMode.Bool("report", false, "Report mode")
-	fModeConfig = fsMode.Bool("config", false, "Config mode")
-	fModeReset = fsMode.Bool("reset", false, "Reset mode")
+	fVerbose = flag.Int("v", 0, "Set verbosity `<level>`: 0 errors only, 1 progress, 2+ USB debug")
+	fJSON = flag.Bool("json", false, "Write errors to stderr as JSON objects, one per line")
+	fDetach = flag.Bool("detach", false, "Claim the HID interface, detaching the kernel driver until exit")
+	fHidraw = flag.Bool("hidraw", false, "Use Linux hidraw nodes instead of libusb, leaving the kernel driver attached")
 )
 
+var fsList = flag.NewFlagSet("list", flag.ContinueOnError)
+
 var (
-	fsReport = flag.NewFlagSet("report", flag.ExitOnError)
+	fsDoctor = flag.NewFlagSet("doctor", flag.ContinueOnError)
+	fDoctorUdev = fsDoctor.String("udev", "", "Write udev rules granting access to `<file>` (\"-\" for stdout)")
+	fDoctorGroup = fsDoctor.String("group", gomagtek.DefaultUdevGroup, "Grant access to members of `<group>` in udev rules")
+)
+
+var (
+	fsInfo = flag.NewFlagSet("info", flag.ContinueOnError)
+	fInfoFormat = fsInfo.String("format", "json", "Write output in `<format>` format: \"json\" or \"xml\"")
+	fInfoMin = fsInfo.Bool("min", false, "Write only the minimal set of fields")
+)
+
+var (
+	fsReport = flag.NewFlagSet("report", flag.ContinueOnError)
 	fReportFile = fsReport.String ("file", "", "Write output to `<file>`")
 	fReportRaw = fsReport.Bool("raw", false, "Write output without headings")
 	fReportStdout = fsReport.Bool("stdout", false, "Write output to stdout")
+	fReportUrl = fsReport.String("url", "", "Upload device info to collector at `<url>`")
+	fReportToken = fsReport.String("token", "", "Authenticate upload with bearer `<token>`")
+	fReportXml = fsReport.Bool("xml", false, "Upload device info as XML instead of JSON")
+	fReportSpool = fsReport.String("spool", "", "Spool failed uploads in `<dir>`")
+	fReportKey = fsReport.String("key", "", "Sign upload with HMAC `<key>`")
 	fReportFormat *string
 	fReportInclude *string
 )
 
 var (
-	fsConfig = flag.NewFlagSet("config", flag.ExitOnError)
+	fsConfig = flag.NewFlagSet("config", flag.ContinueOnError)
 	fConfigErase = fsConfig.Bool("erase", false, "Erase serial number")
 	fConfigEmpty = fsConfig.Bool("empty", true, "Set serial number ONLY if it's empty")
 	fConfigSet = fsConfig.String("set", "", "Set serial number to `<string>`")
 	fConfigUrl = fsConfig.String("url", "", "Set serial number from URL `<url>`")
 	fConfigCopy = fsConfig.Int("copy", 0, "Copy `<n>` characters of factory SN to device SN")
-
+	fConfigTemplate = fsConfig.String("template", "", "Set serial number from template `<tmpl>`, e.g. {site}{factory[0:5]}{check}")
+	fConfigSite = fsConfig.String("site", "", "Use `<code>` for {site} in serial number template")
+	fConfigCounter = fsConfig.Int("counter", 0, "Use `<n>` for {counter} in serial number template, incremented per device")
+	fConfigState = fsConfig.String("state", "", "Apply desired configuration from JSON `<file>`")
+	fConfigDryRun = fsConfig.Bool("dry-run", false, "Show planned changes without writing them")
+	fConfigSync = fsConfig.Bool("sync", false, "Reset devices whose descriptor SN differs from device SN")
+	fConfigMap = fsConfig.String("map", "", "Set serial numbers from factory SN mapping CSV `<file>` (not with -parallel or -timeout)")
+	fConfigResult = fsConfig.String("result", "", "Write mapping results CSV to `<file>` (default stdout)")
+	fConfigOverwrite = fsConfig.Bool("overwrite", false, "Replace existing serial numbers when applying a mapping")
+	fConfigToken = fsConfig.String("token", "", "Authenticate URL request with bearer `<token>`")
+	fConfigKey = fsConfig.String("key", "", "Sign URL request with HMAC `<key>`")
+	fConfigLength = fsConfig.Int("length", 0, "Require serial number of exactly `<n>` characters")
+	fConfigCharset = fsConfig.String("charset", "", "Require serial number characters from `<chars>`")
+	fConfigPrefix = fsConfig.String("prefix", "", "Require serial number to begin with `<prefix>`")
+	fConfigRegex = fsConfig.String("regex", "", "Require serial number to match `<regex>`")
+	fConfigCheck = fsConfig.Bool("check", false, "Require serial number to end with a Luhn mod 36 check digit")
 )
 
 var (
-	fsReset = flag.NewFlagSet("reset", flag.ExitOnError)
+	fsReset = flag.NewFlagSet("reset", flag.ContinueOnError)
 	fResetUsb = fsReset.Bool("usb", false, "Perform a USB reset")
 	fResetDev = fsReset.Bool("dev", false, "Perform a device reset")
+	fResetWait = fsReset.Bool("wait", false, "Wait for the device to reappear after the reset")
+)
+
+var (
+	fsBackup = flag.NewFlagSet("backup", flag.ContinueOnError)
+	fBackupDir = fsBackup.String("dir", ".", "Write backup files named by factory SN to `<dir>`")
+)
+
+var (
+	fsRestore = flag.NewFlagSet("restore", flag.ContinueOnError)
+	fRestoreFile = fsRestore.String("file", "", "Restore configuration from backup `<file>`")
+	fRestorePreview = fsRestore.Bool("preview", false, "Show changes without writing them")
+)
+
+var (
+	fAuditFile string
+	fReadOnly bool
+	fYes bool
+	fAllow string
+	fParallel int
+	fTimeout time.Duration
+	fSelect = gomagtek.NewSelector()
 )
 
 func init() {
 
+	for _, fs := range []*flag.FlagSet{fsList, fsInfo, fsReport, fsConfig, fsReset, fsBackup, fsRestore} {
+		fs.IntVar(&fParallel, "parallel", 1, "Operate on up to `<n>` devices at once")
+		fs.DurationVar(&fTimeout, "timeout", 0, "Give up on a device after `<duration>`, e.g. 30s")
+		fs.StringVar(&fSelect.DeviceSN, "sn", "", "Select device with device SN `<sn>`")
+		fs.StringVar(&fSelect.FactorySN, "fsn", "", "Select device with factory SN `<sn>`")
+		fs.StringVar(&fSelect.BusAddr, "bus", "", "Select device at `<bus:address>`")
+		fs.StringVar(&fSelect.PortPath, "port", "", "Select device at port `<path>`, e.g. 1-2.4")
+		fs.StringVar(&fSelect.ProductID, "pid", "", "Select devices with product ID `<pid>`")
+		fs.IntVar(&fSelect.Index, "index", -1, "Select the `<n>`th candidate device as shown by list")
+	}
+
+	for _, fs := range []*flag.FlagSet{fsConfig, fsReset, fsRestore} {
+		fs.StringVar(&fAuditFile, "audit", "", "Append audit records of changes to `<file>`")
+		fs.BoolVar(&fReadOnly, "readonly", false, "Refuse all writes and resets")
+		fs.BoolVar(&fYes, "yes", false, "Make changes without asking for confirmation")
+		fs.StringVar(&fAllow, "allow", "", "Allow writes only to `<props>` (comma-separated names or IDs)")
+	}
+
 	for _, f := range gomagtek.FieldFlags {
 		includeUsage += fmt.Sprintf("\n\t%q\t%s", f, gomagtek.FieldTitleMap[gomagtek.FlagFieldMap[f]])
 	}
 
-	//fReportInclude = fsReport.String("include", strings.Join(gomagtek.FieldDefaults, ","), includeUsage)
 	fReportInclude = fsReport.String("include", "", includeUsage)
 
 	for _, t := range gomagtek.FormatTypes {
 		formatUsage += fmt.Sprintf("\n\t%q\t%s", t, gomagtek.FormatTitle[t])
 	}
 
-	fReportFormat = fsReport.String("format", "", formatUsage)
+	fReportFormat = fsReport.String("format", "csv", formatUsage)
+
+	commands = map[string]*command {
+		"list":		{fsList, "List candidate devices and their identifiers", false},
+		"info":		{fsInfo, "Write device information in JSON or XML format", false},
+		"report":	{fsReport, "Write a device report, optionally uploading it", false},
+		"config":	{fsConfig, "Configure device serial number and properties", true},
+		"reset":	{fsReset, "Reset devices", true},
+		"backup":	{fsBackup, "Back up device configuration to files", false},
+		"restore":	{fsRestore, "Restore device configuration from a backup file", true},
+		"doctor":	{fsDoctor, "Diagnose device access problems and generate udev rules", false}}
+
+	for name, c := range commands {
+		name, c := name, c
+		c.flags.Usage = func() {
+			fmt.Fprintf(os.Stderr, "Usage: util [global options] %s [options]\n\n%s.\n\nOptions:\n",
+				name, c.summary)
+			c.flags.PrintDefaults()
+		}
+	}
+
+	flag.Usage = usage
+}
+
+// usage describes the global options, the commands, and the exit codes.
+func usage() {
+
+	fmt.Fprintf(os.Stderr, "Usage: util [global options] <command> [options]\n\nCommands:\n")
+
+	for _, name := range commandNames {
+		fmt.Fprintf(os.Stderr, "  %-10s%s\n", name, commands[name].summary)
+	}
+
+	fmt.Fprintf(os.Stderr, "  %-10s%s\n", "help", "Show help for a command")
+	fmt.Fprintf(os.Stderr, "\nGlobal options:\n")
+	flag.PrintDefaults()
+
+	fmt.Fprintf(os.Stderr, "\nExit codes:\n" +
+		"  %d  Success\n" +
+		"  %d  Failure: usage error, or the command failed on every device\n" +
+		"  %d  Partial failure: the command failed on some devices\n" +
+		"  %d  No devices: no matching Magtek devices found\n" +
+		"  %d  Permission denied: devices found but could not be opened\n",
+		exitSuccess, exitFailure, exitPartial, exitNoDevices, exitPermission)
 }
@@ -2,62 +2,640 @@ package main
 
 import (
 	"github.com/jscherff/gomagtek"
+	"path/filepath"
+	"context"
+	"io/ioutil"
 	"strings"
+	"text/tabwriter"
+	"regexp"
+	"sync/atomic"
+	"bufio"
+	"sync"
 	"fmt"
+	"os"
 )
 
-func reset(d *gomagtek.Device) (err error) {
+// wrap adapts a command function to run on every device through the fleet.
+func wrap(f func(context.Context, *gomagtek.Device) error) (gomagtek.FleetFunc) {
+	return func(ctx context.Context, d *gomagtek.Device) (interface{}, error) {
+		return nil, f(ctx, d)
+	}
+}
+
+func reset(ctx context.Context, d *gomagtek.Device) (err error) {
+
+	if !confirm(fmt.Sprintf("Reset device at bus %s address %s?",
+		d.GetBusNumber(), d.GetBusAddress())) {
+		return err
+	}
+
+	if *fResetWait {
+		return resetWait(ctx, d)
+	}
 
 	switch {
 
 	case *fResetUsb:
-		err = d.UsbReset()
+		err = d.UsbResetContext(ctx)
 
 	case *fResetDev:
-		err = d.DeviceReset()
+		err = d.DeviceResetContext(ctx)
 	}
 
 	return err
 }
 
-func report(d *gomagtek.Device) (err error) {
+func resetWait(ctx context.Context, d *gomagtek.Device) (err error) {
 
-	r, err := d.Report(strings.Split(*fReportInclude, ","))
+	var nd *gomagtek.Device
+
+	switch {
+
+	case *fResetUsb:
+		nd, err = d.UsbResetReopen(ctx, usbContext)
+
+	case *fResetDev:
+		nd, err = d.DeviceResetReopen(ctx, usbContext)
+
+	default:
+		return err
+	}
+
+	if err != nil {
+		return err
+	}
 
-	fmt.Println(r.CSV(false) + "\n")
-	fmt.Println(r.CSV(true) + "\n")
-	fmt.Println(r.NVP(false) + "\n")
-	fmt.Println(r.NVP(true) + "\n")
+	defer nd.Close()
+	dsn, _ := nd.GetDescriptSNContext(ctx)
+
+	fmt.Printf("Device at bus %s address %s reappeared at bus %s address %s, descriptor SN %q\n",
+		d.GetBusNumber(), d.GetBusAddress(), nd.GetBusNumber(), nd.GetBusAddress(), dsn)
 
 	return err
 }
 
-func config(d *gomagtek.Device) (err error) {
+// outMutex keeps output from devices handled in parallel from interleaving.
+var outMutex sync.Mutex
 
-	switch {
+func report(ctx context.Context, d *gomagtek.Device) (err error) {
 
-	case *fConfigErase:
-		err = d.EraseDeviceSN()
+	fields := gomagtek.FieldFlags
+
+	if len(*fReportInclude) > 0 {
+		fields = strings.Split(*fReportInclude, ",")
+	}
+
+	r, err := d.ReportContext(ctx, fields)
+
+	if err != nil {
+		return err
+	}
+
+	var out string
+
+	switch *fReportFormat {
+
+	case "csv":
+		out = r.CSV(*fReportRaw) + "\n"
+
+	case "nvp":
+		out = r.NVP(*fReportRaw) + "\n"
+
+	default:
+		return fmt.Errorf("unknown report format: %s", *fReportFormat)
+	}
+
+	outMutex.Lock()
+
+	if len(*fReportFile) == 0 || *fReportStdout {
+		fmt.Print(out)
+	}
+
+	if len(*fReportFile) > 0 {
+		err = appendFile(*fReportFile, out)
+	}
+
+	outMutex.Unlock()
+
+	if len(*fReportUrl) > 0 {
+		if e := upload(ctx, d); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
+
+func appendFile(fn, s string) (err error) {
+
+	f, err := os.OpenFile(fn, os.O_APPEND | os.O_CREATE | os.O_WRONLY, 0640)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err = f.WriteString(s); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+func info(ctx context.Context, d *gomagtek.Device) (err error) {
+
+	di, errs := gomagtek.NewDeviceInfoContext(ctx, d)
+
+	if len(errs) > 0 {
+		err = fmt.Errorf("%v", errs)
+	}
+
+	var b []byte
+	var e error
+
+	switch *fInfoFormat {
+
+	case "json":
+		b, e = di.JSON(*fInfoMin)
+
+	case "xml":
+		b, e = di.XML(*fInfoMin)
+
+	default:
+		return fmt.Errorf("unknown info format: %s", *fInfoFormat)
+	}
+
+	if e != nil {
+		return e
+	}
+
+	outMutex.Lock()
+	fmt.Println(string(b))
+	outMutex.Unlock()
+
+	return err
+}
+
+func upload(ctx context.Context, d *gomagtek.Device) (err error) {
+
+	di, errs := gomagtek.NewDeviceInfoContext(ctx, d)
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%v", errs)
+	}
+
+	u := gomagtek.NewUploader(*fReportUrl)
+	u.Token = *fReportToken
+	u.Key = []byte(*fReportKey)
+
+	if *fReportXml {
+		u.Format = "xml"
+	}
+
+	if len(*fReportSpool) > 0 {
+		if u.Spool, err = spool(); err != nil {
+			return err
+		}
+	}
+
+	if r := u.UploadContext(ctx, di); r.Error != nil && !r.Spooled {
+		return r.Error
+	}
+
+	return err
+}
+
+var (
+	spoolOnce sync.Once
+	spoolShared *gomagtek.Spool
+	spoolErr error
+)
+
+// spool returns the upload spool, shared by all devices so that devices
+// handled in parallel do not drain it more than once.
+func spool() (*gomagtek.Spool, error) {
+
+	spoolOnce.Do(func() {
+		spoolShared, spoolErr = gomagtek.NewSpool(*fReportSpool, 0)
+	})
+
+	return spoolShared, spoolErr
+}
+
+func config(ctx context.Context, d *gomagtek.Device) (err error) {
+
+	if d.SerialPolicy, err = policy(); err != nil {
+		return err
+	}
+
+	ds, err := desired(ctx, d)
+
+	if err != nil {
+		return err
+	}
+
+	pcs, err := d.PlanContext(ctx, ds)
+	id := label(ctx, d)
+
+	printPlan(id, pcs)
+
+	if err != nil || *fConfigDryRun {
+		return err
+	}
+
+	if len(pcs) > 0 && confirm(fmt.Sprintf("Apply these changes to %s?", id)) {
+		if _, err = d.ApplyContext(ctx, ds); err != nil {
+			return err
+		}
+	}
 
-	case *fConfigEmpty:
-		if ln, err := d.GetDeviceSN(); len(ln) > 0 { return err }
+	if *fConfigSync {
+		err = syncSerial(ctx, d)
 	}
 
+	return err
+}
+
+// label identifies a device in output by bus:address and, if it can be read,
+// factory serial number.
+func label(ctx context.Context, d *gomagtek.Device) (id string) {
+
+	id = d.GetBusNumber() + ":" + d.GetBusAddress()
+
+	if fsn, err := d.GetFactorySNContext(ctx); err == nil && len(fsn) > 0 {
+		id += " " + fsn
+	}
+
+	return id
+}
+
+// printPlan writes the planned changes of a device, each prefixed with the
+// device label, without interleaving them with output from other devices.
+func printPlan(id string, pcs []gomagtek.PropertyChange) {
+
+	outMutex.Lock()
+	defer outMutex.Unlock()
+
+	for _, pc := range pcs {
+		fmt.Printf("%s: %v\n", id, pc)
+	}
+}
+
+func syncSerial(ctx context.Context, d *gomagtek.Device) (err error) {
+
+	ss, err := d.SerialStatusContext(ctx)
+
+	if err != nil || !ss.NeedsReset {
+		return err
+	}
+
+	if !confirm(fmt.Sprintf("Descriptor SN %q differs from device SN %q. Reset device?",
+		ss.DescriptSN, ss.DeviceSN)) {
+		return err
+	}
+
+	nd, err := d.SyncSerial(ctx, usbContext)
+
+	if nd != nil && nd != d {
+		defer nd.Close()
+	}
+
+	if err == nil {
+		fmt.Printf("Descriptor SN is now %q\n", ss.DeviceSN)
+	}
+
+	return err
+}
+
+func desired(ctx context.Context, d *gomagtek.Device) (ds *gomagtek.DesiredState, err error) {
+
+	ds = new(gomagtek.DesiredState)
+
+	if len(*fConfigState) > 0 {
+
+		j, err := ioutil.ReadFile(*fConfigState)
+
+		if err != nil {
+			return ds, err
+		}
+
+		if ds, err = gomagtek.NewDesiredStateFromJSON(j); err != nil {
+			return ds, err
+		}
+	}
+
+	sr := &gomagtek.SerialRule{OnlyIfEmpty: *fConfigEmpty}
+
 	switch {
 
 	case *fConfigErase:
-		err = d.EraseDeviceSN()
+		sr.Erase = true
 
 	case len(*fConfigSet) > 0:
-		err = d.SetDeviceSN(*fConfigSet)
+		sr.Value = *fConfigSet
 
 	case len(*fConfigUrl) > 0:
-		//TODO: call function to fetch serail number from server
-		err = d.SetDeviceSN("24F0000")
+		if sn, err := d.GetDeviceSNContext(ctx); err != nil || (sr.OnlyIfEmpty && len(sn) > 0) {
+			return ds, err
+		}
+		if sr.Value, err = fetch(ctx, d); err != nil {
+			return ds, err
+		}
 
 	case *fConfigCopy > 0:
-		err = d.CopyFactorySN(*fConfigCopy)
+		sr.Copy = *fConfigCopy
+
+	case len(*fConfigTemplate) > 0:
+		sr.Template = *fConfigTemplate
+		sr.Counter = nextCounter()
+		sr.Vars = map[string]string{"site": *fConfigSite}
+
+	default:
+		return ds, err
+	}
+
+	ds.DeviceSN = sr
+
+	return ds, err
+}
+
+// counterSeq counts the devices that have taken a template counter value.
+var counterSeq int64
+
+// nextCounter returns the template counter value for the next device, so
+// that every device in a run gets its own serial number: the -counter value
+// for the first, incremented by one for each device after it.
+func nextCounter() int {
+	return *fConfigCounter + int(atomic.AddInt64(&counterSeq, 1) - 1)
+}
+
+func fetch(ctx context.Context, d *gomagtek.Device) (sn string, err error) {
+
+	di, errs := gomagtek.NewDeviceInfoContext(ctx, d)
+
+	if len(errs) > 0 {
+		return sn, fmt.Errorf("%v", errs)
+	}
+
+	p := gomagtek.NewProvisioner(*fConfigUrl)
+	p.Token = *fConfigToken
+	p.Key = []byte(*fConfigKey)
+
+	return p.RequestDeviceSN(di)
+}
+
+func policy() (sp gomagtek.SerialPolicy, err error) {
+
+	sr := &gomagtek.SerialRules {
+		MinLength: *fConfigLength,
+		MaxLength: *fConfigLength,
+		Charset: *fConfigCharset,
+		Prefix: *fConfigPrefix}
+
+	if len(*fConfigRegex) > 0 {
+		if sr.Pattern, err = regexp.Compile(*fConfigRegex); err != nil {
+			return sp, err
+		}
+	}
+
+	if *fConfigCheck {
+		sr.CheckDigit = gomagtek.LuhnMod36
+	}
+
+	return sr, err
+}
+
+// batch applies the serial number mapping file to the devices, writes the
+// results, and returns them.
+func batch(devices []*gomagtek.Device) (rs []gomagtek.BatchResult, err error) {
+
+	sp, err := policy()
+
+	if err != nil {
+		return rs, err
+	}
+
+	for _, d := range devices {
+		d.SerialPolicy = sp
+	}
+
+	f, err := os.Open(*fConfigMap)
+
+	if err != nil {
+		return rs, err
+	}
+
+	defer f.Close()
+
+	m, err := gomagtek.ReadSerialMap(f)
+
+	if err != nil {
+		return rs, err
+	}
+
+	if !confirm(fmt.Sprintf("Apply %d mapping entries to %d devices?", len(m), len(devices))) {
+		return rs, err
+	}
+
+	rs = gomagtek.ApplySerialMap(devices, m, *fConfigOverwrite)
+	out := os.Stdout
+
+	if len(*fConfigResult) > 0 {
+		if out, err = os.Create(*fConfigResult); err != nil {
+			return rs, err
+		}
+		defer out.Close()
+	}
+
+	return rs, gomagtek.WriteBatchResults(out, rs)
+}
+
+func backup(ctx context.Context, d *gomagtek.Device) (err error) {
+
+	b, err := d.BackupContext(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	j, err := b.JSON()
+
+	if err != nil {
+		return err
+	}
+
+	name := b.FactorySN
+
+	if len(name) == 0 {
+		name = fmt.Sprintf("%s-%s", d.GetBusNumber(), d.GetBusAddress())
+	}
+
+	fn := filepath.Join(*fBackupDir, name + ".json")
+
+	if err = ioutil.WriteFile(fn, j, 0640); err == nil {
+		fmt.Printf("Backed up %s to %s\n", name, fn)
 	}
 
 	return err
 }
+
+func restore(ctx context.Context, d *gomagtek.Device) (err error) {
+
+	j, err := ioutil.ReadFile(*fRestoreFile)
+
+	if err != nil {
+		return err
+	}
+
+	b, err := gomagtek.NewBackupFromJSON(j)
+
+	if err != nil {
+		return err
+	}
+
+	pcs, err := d.PlanRestoreContext(ctx, b)
+	id := label(ctx, d)
+
+	printPlan(id, pcs)
+
+	if err != nil || len(pcs) == 0 || *fRestorePreview ||
+		!confirm(fmt.Sprintf("Restore these settings to %s?", id)) {
+		return err
+	}
+
+	_, err = d.RestoreContext(ctx, b)
+
+	return err
+}
+
+func guard() (op *gomagtek.OperationPolicy, err error) {
+
+	op = &gomagtek.OperationPolicy{ReadOnly: fReadOnly}
+
+	if len(fAllow) == 0 {
+		return op, err
+	}
+
+	for _, a := range strings.Split(fAllow, ",") {
+
+		p, ok := gomagtek.LookupProperty(strings.TrimSpace(a))
+
+		if !ok {
+			return op, fmt.Errorf("unknown property: %s", a)
+		}
+
+		op.AllowProperties = append(op.AllowProperties, p.ID)
+	}
+
+	return op, err
+}
+
+var (
+	stdin = bufio.NewReader(os.Stdin)
+	promptMutex sync.Mutex
+)
+
+// confirm asks a yes-or-no question on stderr, so that prompts do not mix
+// with results written to stdout, and reads the answer from stdin. Answers
+// may be piped in, one per line.
+func confirm(prompt string) bool {
+
+	if fYes || fReadOnly {
+		return true
+	}
+
+	promptMutex.Lock()
+	defer promptMutex.Unlock()
+
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", prompt)
+	answer, _ := stdin.ReadString('\n')
+
+	return strings.EqualFold(strings.TrimSpace(answer), "y")
+}
+
+// prompts reports whether the named command will ask for confirmation.
+func prompts(name string) bool {
+
+	if fYes || fReadOnly {
+		return false
+	}
+
+	switch name {
+
+	case "config":
+		return !*fConfigDryRun && len(*fConfigMap) == 0
+
+	case "reset":
+		return true
+
+	case "restore":
+		return !*fRestorePreview
+	}
+
+	return false
+}
+
+func list(devices []*gomagtek.Device) {
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "INDEX\tBUS:ADDR\tPORT\tPID\tDEVICE SN\tFACTORY SN\tPRODUCT")
+
+	for i, d := range devices {
+		sn, _ := d.GetDeviceSN()
+		fsn, _ := d.GetFactorySN()
+		pn, _ := d.GetProductName()
+		fmt.Fprintf(tw, "%d\t%s:%s\t%s\t%s\t%s\t%s\t%s\n", i, d.GetBusNumber(),
+			d.GetBusAddress(), d.GetPortPath(), d.GetProductID(), sn, fsn, pn)
+	}
+
+	tw.Flush()
+}
+
+// doctor reports whether each reader can be opened, and why not, and writes
+// udev rules if requested. It returns the exit code.
+func doctor() (code int) {
+
+	if len(*fDoctorUdev) > 0 {
+
+		rules := gomagtek.UdevRules(*fDoctorGroup, gomagtek.DefaultUdevMode)
+
+		if *fDoctorUdev == "-" {
+			fmt.Print(rules)
+		} else if err := ioutil.WriteFile(*fDoctorUdev, []byte(rules), 0644); err != nil {
+			logError("", err)
+			return exitFailure
+		} else {
+			fmt.Printf("Wrote udev rules to %s\n", *fDoctorUdev)
+		}
+	}
+
+	ads, err := gomagtek.DiagnoseAccess("/sys", "/dev")
+
+	if err != nil {
+		logError("", err)
+		return exitFailure
+	}
+
+	if len(ads) == 0 {
+		logError("", fmt.Errorf("no Magtek devices found"))
+		return exitNoDevices
+	}
+
+	for _, ad := range ads {
+
+		status := "OK"
+
+		if !ad.CanOpen {
+			status = "NO ACCESS"
+			code = exitPermission
+		}
+
+		fmt.Printf("%s\tPID %s\t%s:%s %v\t%s\n", ad.Path, ad.ProductID, ad.Owner, ad.Group, ad.Mode, status)
+
+		for _, p := range ad.Problems {
+			fmt.Printf("\t%s\n", p)
+		}
+	}
+
+	return code
+}
@@ -3,103 +3,326 @@ package main
 import (
 	"github.com/jscherff/gomagtek"
 	"github.com/google/gousb"
+	"encoding/json"
+	"os/signal"
+	"syscall"
+	"errors"
 	"flag"
+	"sync"
 	"log"
 	"fmt"
 	"os"
 )
 
+// Exit codes.
+const (
+	exitSuccess	= 0	// command succeeded on every device
+	exitFailure	= 1	// usage error, or command failed on every device
+	exitPartial	= 2	// command failed on some devices
+	exitNoDevices	= 3	// no matching Magtek devices found
+	exitPermission	= 4	// devices found but could not be opened
+)
+
+var errMutex sync.Mutex
+
+// usbContext is the gousb context used to find devices again after a reset.
+var usbContext *gousb.Context
+
 func main() {
 
-	if len(os.Args) < 2 {
-		fmt.Fprintf(os.Stderr, "You must specify a mode of operation.\n")
-		fsMode.Usage()
-		os.Exit(1)
-	}
+	flag.Parse()
 
-	fsMode.Parse(os.Args[1:2])
+	if flag.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "You must specify a command.\n")
+		usage()
+		os.Exit(exitFailure)
+	}
 
-	var flagset *flag.FlagSet
+	name := flag.Arg(0)
 
-	switch {
+	if name == "help" {
+		help(flag.Arg(1))
+		os.Exit(exitSuccess)
+	}
 
-	case *fModeReport:
-		flagset = fsReport
+	c, ok := commands[name]
 
-	case *fModeConfig:
-		flagset = fsConfig
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", name)
+		usage()
+		os.Exit(exitFailure)
+	}
 
-	case *fModeReset:
-		flagset = fsReset
+	if err := c.flags.Parse(flag.Args()[1:]); err != nil {
+		if err == flag.ErrHelp {
+			os.Exit(exitSuccess)
+		}
+		os.Exit(exitFailure)
 	}
 
-	if flagset.Parse(os.Args[2:]); flagset.NFlag() == 0 {
+	if c.needsOption && c.flags.NFlag() == 0 {
 		fmt.Fprintf(os.Stderr, "You must specify at least one option.\n")
-		flagset.Usage()
-		os.Exit(1)
+		c.flags.Usage()
+		os.Exit(exitFailure)
+	}
+
+	if name == "config" && len(*fConfigMap) > 0 && (fParallel > 1 || fTimeout > 0) {
+		fmt.Fprintf(os.Stderr, "The -parallel and -timeout options cannot be used with -map.\n")
+		os.Exit(exitFailure)
 	}
 
+	if fParallel > 1 && prompts(name) {
+		fmt.Fprintf(os.Stderr, "You must specify -yes to make changes with -parallel.\n")
+		os.Exit(exitFailure)
+	}
+
+	if name == "doctor" {
+		os.Exit(doctor())
+	}
+
+	os.Exit(run(name))
+}
+
+// help shows usage for a command, or for util itself.
+func help(name string) {
+
+	if c, ok := commands[name]; ok {
+		c.flags.Usage()
+	} else {
+		usage()
+	}
+}
+
+// run performs the command on the selected devices and returns the exit code.
+func run(name string) (code int) {
+
 	context := gousb.NewContext()
 	defer context.Close()
 
-	// Open devices that report a Magtek vendor ID, 0x0801.
-	// We omit error checking on OpenDevices() because this
-	// function terminates with 'libusb: not found [code -5]'
-	// on Windows systems.
+	usbContext = context
+
+	if *fVerbose > 1 {
+		context.Debug(*fVerbose - 1)
+	}
+
+	// Open devices that report a Magtek vendor ID, 0x0801,
+	// through libusb or hidraw, reporting those that were found
+	// but could not be opened.
+
+	var magteks []*gomagtek.Device
+	var opts []gomagtek.DeviceOption
+	var errs []error
+
+	if *fVerbose > 0 {
+		opts = append(opts, gomagtek.WithLogger(log.New(os.Stderr, "", log.LstdFlags)))
+	}
+
+	if *fHidraw {
+		magteks, errs = gomagtek.OpenHidrawDevices("/sys", "/dev", opts...)
+	} else {
+		magteks, errs = gomagtek.OpenDevices(context, opts...)
+	}
+
+	for _, d := range magteks {
+		defer d.Close()
+	}
+
+	denied := false
+
+	for _, err := range errs {
+		logError("", err)
+		denied = denied || errors.Is(err, gomagtek.ErrAccess)
+	}
+
+	switch {
+
+	case len(magteks) == 0 && denied:
+		return exitPermission
+
+	case len(magteks) == 0 && len(errs) > 0:
+		return exitFailure
+
+	case len(magteks) == 0:
+		logError("", fmt.Errorf("no Magtek devices found"))
+		return exitNoDevices
+	}
+
+	var audit gomagtek.AuditSink
+
+	if len(fAuditFile) > 0 {
+
+		af, err := gomagtek.NewAuditFile(fAuditFile)
+
+		if err != nil {
+			logError("", err)
+			return exitFailure
+		}
+
+		defer af.Close()
+		audit = af
+	}
+
+	op, err := guard()
+
+	if err != nil {
+		logError("", err)
+		return exitFailure
+	}
+
+	for _, d := range magteks {
+		d.Audit = audit
+		d.OperationPolicy = op
+	}
+
+	if magteks, err = fSelect.Select(magteks); err != nil {
+		logError("", err)
+		return exitFailure
+	}
 
-	devices, _ := context.OpenDevices(func(desc *gousb.DeviceDesc) bool {
-		return uint16(desc.Vendor) == gomagtek.MagtekVendorID
-	})
+	if len(magteks) == 0 {
+		logError("", fmt.Errorf("no matching Magtek devices found"))
+		return exitNoDevices
+	}
 
-	if len(devices) == 0 {
-		log.Fatalf("No Magtek devices found")
+	if *fDetach {
+		detach(magteks)
 	}
 
-	for _, device := range devices {
+	var fn gomagtek.FleetFunc
+
+	switch {
+
+	case name == "list":
+		list(magteks)
+		return exitSuccess
+
+	case name == "info":
+		fn = wrap(info)
 
-		defer device.Close()
-		device, err := gomagtek.NewDevice(device)
+	case name == "report":
+		fn = wrap(report)
 
+	case name == "config" && len(*fConfigMap) > 0:
+		rs, err := batch(magteks)
 		if err != nil {
-			log.Fatalf("Error: %v", err); continue
+			logError("", err)
+			return exitFailure
 		}
+		return batchExit(rs, len(errs))
+
+	case name == "config":
+		fn = wrap(config)
+
+	case name == "reset":
+		fn = wrap(reset)
+
+	case name == "backup":
+		fn = wrap(backup)
 
-		/*
-		di, errs := gomagtek.NewDeviceInfo(device)
+	case name == "restore":
+		fn = wrap(restore)
+	}
+
+	failed := 0
+	fleet := gomagtek.NewFleet(fParallel, fTimeout)
+
+	for _, r := range fleet.Run(magteks, fn) {
 
-		if len(errs) > 0 {
-			log.Fatalf("Errors encountered"); continue
+		id := r.BusNumber + ":" + r.BusAddress
+
+		if r.Error != nil {
+			logError(id, r.Error)
+			failed++
+		} else if *fVerbose > 0 {
+			log.Printf("%s: %s completed in %v", id, name, r.Duration)
 		}
-		*/
 
-		dx := []byte("<DeviceInfo><HostName>John-SurfacePro</HostName><VendorID>0801</VendorID><ProductID>0001</ProductID><VendorName>Mag-Tek</VendorName><ProductName>USB Swipe Reader</ProductName><ProductVer>V05</ProductVer><SoftwareID>21042840G01</SoftwareID><DeviceSN>B164F78</DeviceSN><FactorySN>B164F78022713AA</FactorySN><DescriptSN>B164F78</DescriptSN><BusNumber>1</BusNumber><BusAddress>29</BusAddress><USBSpec>1.10</USBSpec><USBClass>per-interface</USBClass><USBSubclass>per-interface</USBSubclass><USBProtocol>0</USBProtocol><DeviceSpeed>full</DeviceSpeed><DeviceVer>1.00</DeviceVer><MaxPktSize>8</MaxPktSize><BufferSize>60</BufferSize></DeviceInfo>")
+		if s := r.Device.Stats(); *fVerbose > 0 {
+			log.Printf("%s: %d commands, %d retries, %d failures, %d pipe errors, %d timeouts",
+				id, s.Commands, s.Retries, s.Failures, s.PipeErrors, s.Timeouts)
+		}
+	}
 
-		di, err := gomagtek.NewDeviceInfoFromXML(dx)
-		fmt.Println(di)
+	switch {
+
+	case failed == len(magteks):
+		return exitFailure
+
+	case failed > 0 || len(errs) > 0:
+		return exitPartial
+	}
+
+	return exitSuccess
+}
 
-		di.SoftwareID = ""
+// batchExit returns the exit code for the results of a mapping run. Readers
+// that failed and mapping entries with no reader count as failures, as do
+// devices that could not be opened.
+func batchExit(rs []gomagtek.BatchResult, openErrs int) (code int) {
 
-		b, _ := di.JSON(true)
-		fmt.Println(string(b) + "\n")
+	failed := 0
 
-		b, _ = di.XML(true)
-		fmt.Println(string(b) + "\n")
+	for _, r := range rs {
+		if r.Status == gomagtek.BatchFailed || r.Status == gomagtek.BatchMissing {
+			failed++
+		}
+	}
+
+	switch {
 
-		b, _ = di.FXML(true)
-		fmt.Println(string(b) + "\n")
+	case failed > 0 && failed == len(rs):
+		return exitFailure
 
-		os.Exit(0)
+	case failed > 0 || openErrs > 0:
+		return exitPartial
+	}
 
-		switch {
+	return exitSuccess
+}
 
-		case *fModeReport:
-			err = report(device)
+// detach claims the interface of each device, detaching the kernel driver,
+// and makes sure the drivers are reattached if util is interrupted.
+func detach(devices []*gomagtek.Device) {
 
-		case *fModeConfig:
-			err = config(device)
+	for _, d := range devices {
 
-		case *fModeReset:
-			err = reset(device)
+		d.AutoDetach = true
+
+		if err := d.Claim(); err != nil {
+			logError(d.GetBusNumber() + ":" + d.GetBusAddress(), err)
 		}
 	}
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-c
+		for _, d := range devices {
+			d.Close()
+		}
+		os.Exit(exitFailure)
+	}()
+}
+
+// logError writes an error to stderr, as a JSON object if requested. The
+// device is identified by "bus:address" and may be empty.
+func logError(device string, err error) {
+
+	errMutex.Lock()
+	defer errMutex.Unlock()
+
+	if !*fJSON {
+		if len(device) > 0 {
+			log.Printf("Error: %s: %v", device, err)
+		} else {
+			log.Printf("Error: %v", err)
+		}
+		return
+	}
+
+	json.NewEncoder(os.Stderr).Encode(struct {
+		Device string `json:"device,omitempty"`
+		Error string `json:"error"`
+	}{device, err.Error()})
 }